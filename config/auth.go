@@ -0,0 +1,79 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Auth describes authentication of signed datagrams
+type Auth struct {
+	KeyringFile      string `json:"keyringFile"`      // file with key IDs and keys, authentication is disabled if not set
+	Unsigned         string `json:"unsigned"`         // action with unsigned datagrams: accept, tag or reject
+	TagField         string `json:"tagField"`         // record field which tells if datagram was signed when unsigned is tag
+	RequireTimestamp bool   `json:"requireTimestamp"` // reject signed datagrams without timestamp
+	MaxSkew          int    `json:"maxSkew"`          // maximum difference in seconds between timestamp and receive time
+}
+
+// LoadKeyring reads keyring file with lines of key ID and key separated
+// by white space, empty lines and lines starting with # are ignored
+func LoadKeyring(file string) (map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected key ID and key", file, n)
+		}
+		if _, ok := keys[fields[0]]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key ID %s", file, n, fields[0])
+		}
+		keys[fields[0]] = []byte(fields[1])
+	}
+	return keys, scanner.Err()
+}
+
+// setAuthDefaults assigns default values of authentication parameters
+func (c *Configuration) setAuthDefaults() {
+	if c.Auth.Unsigned == "" {
+		c.Auth.Unsigned = "accept"
+	}
+	if c.Auth.TagField == "" {
+		c.Auth.TagField = "signed"
+	}
+	if c.Auth.MaxSkew == 0 {
+		c.Auth.MaxSkew = 300 // in seconds
+	}
+}
+
+// validateAuth adds all problems of authentication parameters to given error
+func (c *Configuration) validateAuth(verr *ValidationError) {
+	a := c.Auth
+	if a.Unsigned != "accept" && a.Unsigned != "tag" && a.Unsigned != "reject" {
+		verr.add("auth: unsigned %q must be accept, tag or reject", a.Unsigned)
+	}
+	if a.MaxSkew < 0 {
+		verr.add("auth: maxSkew %d must be positive", a.MaxSkew)
+	}
+	if a.KeyringFile == "" {
+		if a.Unsigned != "accept" {
+			verr.add("auth: keyringFile is required when unsigned is %s", a.Unsigned)
+		}
+		return
+	}
+	if keys, err := LoadKeyring(a.KeyringFile); err != nil {
+		verr.add("auth: keyringFile: %v", err)
+	} else if len(keys) == 0 {
+		verr.add("auth: keyringFile %s has no keys", a.KeyringFile)
+	}
+}
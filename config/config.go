@@ -0,0 +1,320 @@
+package config
+
+// config - configuration of UDP collector shared by the UDP server
+//          and its monitoring server
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration stores server configuration parameters
+type Configuration struct {
+	Port                 int               `json:"port" reload:"restart"`              // server port number
+	IPAddr               string            `json:"ipAddr" reload:"restart"`            // server ip address to bind
+	Family               string            `json:"family" reload:"restart"`            // address family of the server: dual, ipv4 or ipv6
+	MonitorPort          int               `json:"monitorPort" reload:"restart"`       // server monitor port number
+	MonitorInterval      int               `json:"monitorInterval"`                    // monitor health interval in seconds
+	MonitorToken         string            `json:"monitorToken" secret:"true"`         // bearer token required by monitor reload from other hosts
+	MonitorTokenFile     string            `json:"monitorTokenFile"`                   // file with monitor bearer token
+	BufSize              int               `json:"bufSize"`                            // buffer size
+	StompURI             string            `json:"stompURI"`                           // StompAMQ URI
+	StompLogin           string            `json:"stompLogin"`                         // StompAQM login name
+	StompPassword        string            `json:"stompPassword" secret:"true"`        // StompAQM password
+	StompLoginFile       string            `json:"stompLoginFile"`                     // file with StompAQM login name
+	StompPasswordFile    string            `json:"stompPasswordFile"`                  // file with StompAQM password
+	StompIterations      int               `json:"stompIterations"`                    // Stomp iterations
+	SendTimeout          int               `json:"sendTimeout"`                        // heartbeat send timeout in seconds
+	RecvTimeout          int               `json:"recvTimeout"`                        // heartbeat recv timeout in seconds
+	HeartBeatGracePeriod float64           `json:"heartBeatGracePeriod"`               // is used to calculate the read heart-beat timeout
+	Endpoint             string            `json:"endpoint"`                           // StompAMQ endpoint
+	ContentType          string            `json:"contentType"`                        // ContentType of UDP packet
+	LogFile              string            `json:"logFile" reload:"restart"`           // log file name
+	LogLevel             string            `json:"logLevel"`                           // log level: debug, info, warn or error
+	LogFormat            string            `json:"logFormat" reload:"restart"`         // log format: text or json
+	LogRepeatInterval    int               `json:"logRepeatInterval" reload:"restart"` // interval in seconds to suppress repeated errors, negative to disable
+	RejectedPackets      int               `json:"rejectedPackets"`                    // number of last rejected packets to keep for inspection
+	RejectedPayloadSize  int               `json:"rejectedPayloadSize"`                // maximum size of kept rejected packet payload
+	CompressionPrefixes  map[string]string `json:"compressionPrefixes"`                // prefixes of compressed payloads per compression type
+	MaxDecompressedSize  int               `json:"maxDecompressedSize"`                // maximum size of decompressed payload
+	Decoder              string            `json:"decoder"`                            // decoder of received packets: json, msgpack, cbor or xrootd
+	Encoding             string            `json:"encoding"`                           // encoding of records sent to StompAMQ: json, msgpack or cbor
+	Verbose              bool              `json:"verbose"`                            // verbose output
+	Allow                []string          `json:"allow"`                              // source networks allowed to send records, all if empty
+	Deny                 []string          `json:"deny"`                               // source networks not allowed to send records
+	RateLimit            RateLimit         `json:"rateLimit"`                          // per-source, per-subnet and global rate limits
+	Auth                 Auth              `json:"auth"`                               // authentication of signed datagrams
+	Metadata             Metadata          `json:"metadata"`                           // receive metadata attached to records
+	Listeners            []Listener        `json:"listeners" reload:"members"`         // listeners and their pipelines, adding or removing requires restart
+	Sinks                []Sink            `json:"sinks"`                              // destinations of received records
+}
+
+// ValidationError lists all problems found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+// Error implements error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(e.Problems, "; "))
+}
+
+// add records new problem
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// Load reads given config file, applies environment and file overrides
+// and default values and validates it
+func Load(configFile string) (*Configuration, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", configFile, err)
+	}
+	var c Configuration
+	if err := unmarshal(configFile, data, &c); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", configFile, err)
+	}
+	verr := &ValidationError{}
+	applyEnv(&c, verr)
+	applyFiles(&c, verr)
+	c.SetDefaults()
+	c.validate(verr)
+	if len(verr.Problems) > 0 {
+		return nil, verr
+	}
+	return &c, nil
+}
+
+// unmarshal parses configuration data in JSON, YAML or TOML format
+// depending on config file extension
+func unmarshal(configFile string, data []byte, c *Configuration) error {
+	var params map[string]interface{}
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &params); err != nil {
+			return err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &params); err != nil {
+			return err
+		}
+	default:
+		return json.Unmarshal(data, c)
+	}
+	// convert parsed parameters to JSON to use the same parameter names
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, c)
+}
+
+// SetDefaults assigns default values to all unset parameters
+func (c *Configuration) SetDefaults() {
+	if c.Port == 0 {
+		c.Port = 9331 // default port
+	}
+	if c.MonitorPort == 0 {
+		c.MonitorPort = 9330 // default port
+	}
+	if c.MonitorInterval == 0 {
+		c.MonitorInterval = 10 // in seconds
+	}
+	if c.BufSize == 0 {
+		c.BufSize = 1024 // 1 KByte
+	}
+	if c.StompIterations == 0 {
+		c.StompIterations = 3 // number of Stomp attempts
+	}
+	if c.Decoder == "" {
+		c.Decoder = "json"
+	}
+	if c.Encoding == "" {
+		c.Encoding = "json"
+	}
+	if c.ContentType == "" {
+		c.ContentType = "application/" + c.Encoding
+	}
+	if c.HeartBeatGracePeriod == 0 {
+		c.HeartBeatGracePeriod = 1
+	}
+	if c.SendTimeout == 0 {
+		c.SendTimeout = 600 // in seconds
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = "info"
+	}
+	if c.LogFormat == "" {
+		c.LogFormat = "text"
+	}
+	if c.LogRepeatInterval == 0 {
+		c.LogRepeatInterval = 60 // in seconds
+	}
+	if c.RejectedPackets == 0 {
+		c.RejectedPackets = 100
+	}
+	if c.RejectedPayloadSize == 0 {
+		c.RejectedPayloadSize = 1000 // 1 KByte
+	}
+	if c.MaxDecompressedSize == 0 {
+		c.MaxDecompressedSize = 1024 * 1024 // 1 MByte
+	}
+	c.setAuthDefaults()
+	c.setRateLimitDefaults()
+	c.setSinkDefaults()
+	c.setListenerDefaults()
+}
+
+// Validate checks configuration parameters and reports every problem found
+func (c *Configuration) Validate() error {
+	verr := &ValidationError{}
+	c.validate(verr)
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// validate adds all problems of configuration parameters to given error
+func (c *Configuration) validate(verr *ValidationError) {
+	if c.Port < 1 || c.Port > 65535 {
+		verr.add("port %d is out of range 1-65535", c.Port)
+	}
+	if c.Family != "" && !validFamily(c.Family) {
+		verr.add("family %q must be dual, ipv4 or ipv6", c.Family)
+	}
+	if c.MonitorPort < 1 || c.MonitorPort > 65535 {
+		verr.add("monitorPort %d is out of range 1-65535", c.MonitorPort)
+	}
+	if c.MonitorInterval < 0 {
+		verr.add("monitorInterval %d must be positive", c.MonitorInterval)
+	}
+	if c.BufSize < 0 {
+		verr.add("bufSize %d must be positive", c.BufSize)
+	}
+	if c.StompIterations < 0 {
+		verr.add("stompIterations %d must be positive", c.StompIterations)
+	}
+	if c.SendTimeout < 0 {
+		verr.add("sendTimeout %d must not be negative", c.SendTimeout)
+	}
+	if c.RecvTimeout < 0 {
+		verr.add("recvTimeout %d must not be negative", c.RecvTimeout)
+	}
+	if c.HeartBeatGracePeriod < 0 {
+		verr.add("heartBeatGracePeriod %v must not be negative", c.HeartBeatGracePeriod)
+	}
+	if c.RejectedPackets < 0 {
+		verr.add("rejectedPackets %d must not be negative", c.RejectedPackets)
+	}
+	if c.RejectedPayloadSize < 0 {
+		verr.add("rejectedPayloadSize %d must not be negative", c.RejectedPayloadSize)
+	}
+	for ctype, prefix := range c.CompressionPrefixes {
+		if ctype != "gzip" && ctype != "zstd" && ctype != "snappy" {
+			verr.add("compressionPrefixes: unsupported compression %q, must be gzip, zstd or snappy", ctype)
+		}
+		if prefix == "" {
+			verr.add("compressionPrefixes: empty prefix for %s compression", ctype)
+		}
+	}
+	if c.MaxDecompressedSize < 0 {
+		verr.add("maxDecompressedSize %d must be positive", c.MaxDecompressedSize)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		verr.add("logLevel %q must be one of debug, info, warn or error", c.LogLevel)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		verr.add("logFormat %q must be text or json", c.LogFormat)
+	}
+	for _, param := range []struct {
+		name     string
+		networks []string
+	}{{"allow", c.Allow}, {"deny", c.Deny}} {
+		for _, n := range param.networks {
+			if _, err := ParseNetwork(n); err != nil {
+				verr.add("%s: %v", param.name, err)
+			}
+		}
+	}
+	c.validateAuth(verr)
+	c.validateMetadata(verr)
+	c.validateRateLimit(verr)
+	c.validateSinks(verr)
+	c.validateListeners(verr)
+}
+
+// validEncoding checks if given encoding is supported
+func validEncoding(name string) bool {
+	return name == "json" || name == "msgpack" || name == "cbor"
+}
+
+// Masked returns deep copy of configuration with secret parameters
+// masked, including the ones of sinks
+func (c Configuration) Masked() Configuration {
+	var m Configuration
+	if data, err := json.Marshal(c); err == nil {
+		json.Unmarshal(data, &m)
+	}
+	maskSecrets(reflect.ValueOf(&m).Elem())
+	return m
+}
+
+// maskSecrets masks secret string fields of given value and of all
+// nested structures
+func maskSecrets(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if v.Type().Field(i).Tag.Get("secret") == "true" {
+				maskValue(f)
+				continue
+			}
+			maskSecrets(f)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			maskSecrets(v.Index(i))
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			maskSecrets(v.Elem())
+		}
+	}
+}
+
+// maskValue masks secret string or values of secret map of strings
+func maskValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(mask(v.String()))
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		for _, key := range v.MapKeys() {
+			v.SetMapIndex(key, reflect.ValueOf(mask(v.MapIndex(key).String())))
+		}
+	}
+}
+
+// String implements Stringer interface and never exposes secrets
+func (c Configuration) String() string {
+	data, err := json.Marshal(c.Masked())
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix is a prefix of environment variables which override
+// configuration parameters, e.g. UDP_COLLECTOR_STOMP_PASSWORD
+const EnvPrefix = "UDP_COLLECTOR_"
+
+// EnvName returns name of environment variable for given configuration
+// parameter, e.g. stompURI becomes UDP_COLLECTOR_STOMP_URI
+func EnvName(param string) string {
+	runes := []rune(param)
+	var sb strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				sb.WriteRune('_')
+			}
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return EnvPrefix + sb.String()
+}
+
+// applyEnv overrides configuration parameters from environment variables,
+// variables of structured parameters like sinks or listeners are ignored
+func applyEnv(c *Configuration, verr *ValidationError) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := EnvName(fieldName(v.Type().Field(i)))
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		f := v.Field(i)
+		if !scalarKind(f.Kind()) && !(f.Kind() == reflect.Slice && scalarKind(f.Type().Elem().Kind())) {
+			slog.Warn("ignoring environment variable of structured parameter", "name", name)
+			continue
+		}
+		// Kubernetes injects service links like UDP_COLLECTOR_PORT=udp://10.0.0.1:9331
+		// for a service named udp-collector
+		if f.Kind() != reflect.String && (strings.HasPrefix(val, "tcp://") || strings.HasPrefix(val, "udp://")) {
+			slog.Warn("ignoring Kubernetes service link environment variable", "name", name, "value", val)
+			continue
+		}
+		if err := setValue(f, val); err != nil {
+			verr.add("%s: %v", name, err)
+		}
+	}
+}
+
+// scalarKind checks whether parameter of given kind can be set from a string
+func scalarKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Int, reflect.Float64, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+// setValue sets value of a field from its string representation, slices
+// are given as comma separated values
+func setValue(f reflect.Value, val string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(val)
+	case reflect.Int:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", val)
+		}
+		f.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", val)
+		}
+		f.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", val)
+		}
+		f.SetBool(b)
+	case reflect.Slice:
+		items := reflect.MakeSlice(f.Type(), 0, 0)
+		for _, item := range strings.Split(val, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			elem := reflect.New(f.Type().Elem()).Elem()
+			if err := setValue(elem, item); err != nil {
+				return err
+			}
+			items = reflect.Append(items, elem)
+		}
+		f.Set(items)
+	default:
+		return fmt.Errorf("unsupported parameter type %s", f.Kind())
+	}
+	return nil
+}
+
+// fileParam describes parameter which may be read from a file
+type fileParam struct {
+	name  string  // name of the file parameter
+	value *string // parameter to set
+	file  string  // file to read
+}
+
+// applyFiles reads parameters provided via files, e.g. mounted Kubernetes
+// secrets, the file is only used if parameter is not set explicitly
+func applyFiles(c *Configuration, verr *ValidationError) {
+	params := []fileParam{
+		{"stompLoginFile", &c.StompLogin, c.StompLoginFile},
+		{"stompPasswordFile", &c.StompPassword, c.StompPasswordFile},
+		{"monitorTokenFile", &c.MonitorToken, c.MonitorTokenFile},
+	}
+	for i := range c.Sinks {
+		s := &c.Sinks[i]
+		params = append(params,
+			fileParam{fmt.Sprintf("sink %s: stomp loginFile", s.Name), &s.Stomp.Login, s.Stomp.LoginFile},
+			fileParam{fmt.Sprintf("sink %s: stomp passwordFile", s.Name), &s.Stomp.Password, s.Stomp.PasswordFile},
+			fileParam{fmt.Sprintf("sink %s: amqp passwordFile", s.Name), &s.AMQP.Password, s.AMQP.PasswordFile},
+			fileParam{fmt.Sprintf("sink %s: opensearch passwordFile", s.Name), &s.OpenSearch.Password, s.OpenSearch.PasswordFile},
+			fileParam{fmt.Sprintf("sink %s: opensearch tokenFile", s.Name), &s.OpenSearch.Token, s.OpenSearch.TokenFile},
+			fileParam{fmt.Sprintf("sink %s: nats passwordFile", s.Name), &s.NATS.Password, s.NATS.PasswordFile},
+			fileParam{fmt.Sprintf("sink %s: nats tokenFile", s.Name), &s.NATS.Token, s.NATS.TokenFile})
+		if s.Kafka.SASL != nil {
+			params = append(params,
+				fileParam{fmt.Sprintf("sink %s: kafka sasl passwordFile", s.Name), &s.Kafka.SASL.Password, s.Kafka.SASL.PasswordFile})
+		}
+	}
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		params = append(params, fileParam{fmt.Sprintf("listener %s: tokenFile", l.Name), &l.Token, l.TokenFile})
+	}
+	for _, p := range params {
+		if p.file == "" || *p.value != "" {
+			continue
+		}
+		data, err := os.ReadFile(p.file)
+		if err != nil {
+			verr.add("%s: %v", p.name, err)
+			continue
+		}
+		*p.value = strings.TrimRight(string(data), "\r\n")
+	}
+}
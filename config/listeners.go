@@ -0,0 +1,229 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// Listener describes single listener and its processing pipeline
+type Listener struct {
+	Name           string      `json:"name"`                       // listener name used in logs and metrics
+	Protocol       string      `json:"protocol" reload:"restart"`  // listener protocol: udp, tcp or http
+	Address        string      `json:"address" reload:"restart"`   // address to bind, e.g. :9331 or [::1]:9331
+	Addresses      []string    `json:"addresses" reload:"restart"` // additional addresses to bind
+	Family         string      `json:"family" reload:"restart"`    // address family: dual, ipv4 or ipv6
+	Decoder        string      `json:"decoder"`                    // decoder of received packets: json, msgpack, cbor or xrootd
+	BufSize        int         `json:"bufSize"`                    // initial buffer size
+	Transforms     *Transforms `json:"transforms"`                 // transformations of received records
+	Sinks          []string    `json:"sinks"`                      // names of sinks to deliver records to, all sinks if empty
+	MaxConnections int         `json:"maxConnections"`             // maximum number of concurrent TCP connections
+	IdleTimeout    int         `json:"idleTimeout"`                // TCP or HTTP connection idle timeout in seconds
+	TLS            *TLSConfig  `json:"tls" reload:"restart"`       // TLS parameters of TCP or HTTP listener, plain TCP if not set
+	MaxBodySize    int         `json:"maxBodySize"`                // maximum size of HTTP request body
+	Token          string      `json:"token" secret:"true"`        // bearer token required by HTTP listener
+	TokenFile      string      `json:"tokenFile"`                  // file with bearer token
+}
+
+// Transforms describes transformations applied to every received record
+type Transforms struct {
+	Rename map[string]string      `json:"rename"` // fields to rename, old name to new name
+	Drop   []string               `json:"drop"`   // fields to remove
+	Set    map[string]interface{} `json:"set"`    // fields to add or replace
+}
+
+// TLSConfig describes TLS parameters of TCP or HTTP listener
+type TLSConfig struct {
+	CertFile     string `json:"certFile"`     // server certificate file
+	KeyFile      string `json:"keyFile"`      // server key file
+	ClientCAFile string `json:"clientCAFile"` // CA file to verify client certificates, no client authentication if not set
+}
+
+// defaultTransforms returns transformations applied to CMSSW records
+// when listener does not specify them
+func defaultTransforms() *Transforms {
+	return &Transforms{Rename: map[string]string{"type": "read_type"}}
+}
+
+// setListenerDefaults creates default listener from top-level parameters
+// if no listeners are configured and assigns defaults to all listeners
+func (c *Configuration) setListenerDefaults() {
+	if len(c.Listeners) == 0 {
+		c.Listeners = []Listener{{
+			Name:    "default",
+			Address: net.JoinHostPort(c.IPAddr, strconv.Itoa(c.Port)),
+			Family:  c.Family,
+			Decoder: c.Decoder,
+		}}
+	}
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if l.Name == "" {
+			l.Name = fmt.Sprintf("listener%d", i)
+		}
+		if l.Protocol == "" {
+			l.Protocol = "udp"
+		}
+		if l.Family == "" {
+			l.Family = "dual"
+		}
+		if l.Decoder == "" {
+			l.Decoder = "json"
+		}
+		if l.BufSize == 0 {
+			l.BufSize = c.BufSize
+		}
+		if l.Protocol == "tcp" && l.MaxConnections == 0 {
+			l.MaxConnections = 1000
+		}
+		if l.Protocol == "http" && l.MaxBodySize == 0 {
+			l.MaxBodySize = 1024 * 1024 // 1 MByte
+		}
+		if l.Protocol != "udp" && l.IdleTimeout == 0 {
+			l.IdleTimeout = 300 // in seconds
+		}
+		if l.Transforms == nil {
+			l.Transforms = defaultTransforms()
+		}
+	}
+}
+
+// validateListeners adds all problems of listeners to given error
+func (c *Configuration) validateListeners(verr *ValidationError) {
+	names := make(map[string]bool)
+	sinks := make(map[string]bool)
+	for _, s := range c.Sinks {
+		sinks[s.Name] = true
+	}
+	for _, l := range c.Listeners {
+		if names[l.Name] {
+			verr.add("listener %s: duplicate name", l.Name)
+		}
+		names[l.Name] = true
+		if l.Protocol != "udp" && l.Protocol != "tcp" && l.Protocol != "http" {
+			verr.add("listener %s: unsupported protocol %q", l.Name, l.Protocol)
+		}
+		if l.Protocol == "tcp" {
+			if l.Decoder != "json" {
+				verr.add("listener %s: tcp listener accepts newline-delimited json only, decoder %q is not supported", l.Name, l.Decoder)
+			}
+			if l.MaxConnections < 0 {
+				verr.add("listener %s: maxConnections %d must be positive", l.Name, l.MaxConnections)
+			}
+		}
+		if l.IdleTimeout < 0 {
+			verr.add("listener %s: idleTimeout %d must be positive", l.Name, l.IdleTimeout)
+		}
+		if l.MaxBodySize < 0 {
+			verr.add("listener %s: maxBodySize %d must be positive", l.Name, l.MaxBodySize)
+		}
+		if l.Protocol != "http" && (l.Token != "" || l.TokenFile != "") {
+			verr.add("listener %s: token is supported by http listeners only", l.Name)
+		}
+		if l.TLS != nil {
+			if l.Protocol == "udp" {
+				verr.add("listener %s: tls is supported by tcp and http listeners only", l.Name)
+			}
+			if l.TLS.CertFile == "" || l.TLS.KeyFile == "" {
+				verr.add("listener %s: tls certFile and keyFile are required", l.Name)
+			}
+		}
+		if !validFamily(l.Family) {
+			verr.add("listener %s: family %q must be dual, ipv4 or ipv6", l.Name, l.Family)
+		}
+		for _, addr := range l.BindAddresses() {
+			if err := validateAddress(addr, l.Family); err != nil {
+				verr.add("listener %s: invalid address %q: %v", l.Name, addr, err)
+			}
+		}
+		if !validEncoding(l.Decoder) && l.Decoder != "xrootd" {
+			verr.add("listener %s: decoder %q must be json, msgpack, cbor or xrootd", l.Name, l.Decoder)
+		}
+		if l.BufSize < 0 {
+			verr.add("listener %s: bufSize %d must be positive", l.Name, l.BufSize)
+		}
+		for _, name := range l.Sinks {
+			if !sinks[name] {
+				verr.add("listener %s: unknown sink %q", l.Name, name)
+			}
+		}
+	}
+}
+
+// validFamily checks if given address family is supported
+func validFamily(family string) bool {
+	return family == "dual" || family == "ipv4" || family == "ipv6"
+}
+
+// validateAddress checks that given address consists of IP address of
+// given family, empty for all addresses, and valid port
+func validateAddress(addr, family string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("port %q is out of range 1-65535", port)
+	}
+	if host == "" {
+		return nil
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("host %q is not an IP address", host)
+	}
+	if family == "ipv4" && !ip.Unmap().Is4() {
+		return fmt.Errorf("%s is not an IPv4 address", host)
+	}
+	if family == "ipv6" && ip.Is4() {
+		return fmt.Errorf("%s is not an IPv6 address", host)
+	}
+	return nil
+}
+
+// BindAddresses returns all addresses the listener binds to
+func (l *Listener) BindAddresses() []string {
+	return append([]string{l.Address}, l.Addresses...)
+}
+
+// Network returns network name of the listener protocol and address
+// family, HTTP listeners use TCP network
+func (l *Listener) Network() string {
+	network := "tcp"
+	if l.Protocol == "udp" {
+		network = "udp"
+	}
+	switch l.Family {
+	case "ipv4":
+		return network + "4"
+	case "ipv6":
+		return network + "6"
+	}
+	return network
+}
+
+// LocalAddress returns address to reach the listener from local host
+func (l *Listener) LocalAddress() string {
+	host, port, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return l.Address
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !ip.IsUnspecified() {
+		return l.Address
+	}
+	if l.Family == "ipv6" {
+		return net.JoinHostPort("::1", port)
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// Listener returns listener configuration with given name
+func (c *Configuration) Listener(name string) *Listener {
+	for i := range c.Listeners {
+		if c.Listeners[i].Name == name {
+			return &c.Listeners[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// metadata fields which can be attached to received records
+var metadataFields = []string{"receiveTime", "sourceIP", "sourcePort", "hostname", "instanceID", "listener", "sequence"}
+
+// Metadata describes receive metadata attached to every record
+type Metadata struct {
+	Fields     map[string]string `json:"fields"`     // names of record fields per metadata field, e.g. receiveTime: receive_ts
+	Nest       string            `json:"nest"`       // record field to nest metadata under, top-level fields if empty
+	InstanceID string            `json:"instanceID"` // collector instance ID, random one if not set
+}
+
+// validateMetadata adds all problems of metadata parameters to given error
+func (c *Configuration) validateMetadata(verr *ValidationError) {
+	var unknown []string
+	for name := range c.Metadata.Fields {
+		if !slices.Contains(metadataFields, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		verr.add("metadata: unknown field %q, must be one of %s", name, strings.Join(metadataFields, ", "))
+	}
+}
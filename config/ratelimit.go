@@ -0,0 +1,84 @@
+package config
+
+import "math"
+
+// RateLimit describes token-bucket rate limits of received packets
+type RateLimit struct {
+	Rate         float64       `json:"rate"`         // packets per second per source address, 0 disables the limit
+	Burst        int           `json:"burst"`        // burst of packets per source address
+	GlobalRate   float64       `json:"globalRate"`   // packets per second of all sources, 0 disables the limit
+	GlobalBurst  int           `json:"globalBurst"`  // burst of packets of all sources
+	Subnets      []SubnetLimit `json:"subnets"`      // limits of all sources in given networks
+	MaxSources   int           `json:"maxSources"`   // maximum number of tracked source addresses
+	Action       string        `json:"action"`       // action with excess packets: drop or sample
+	SampleRate   int           `json:"sampleRate"`   // one of sampleRate excess packets is kept if action is sample
+	TopOffenders int           `json:"topOffenders"` // number of top offenders exported as metrics
+}
+
+// SubnetLimit describes rate limit of all sources in a network
+type SubnetLimit struct {
+	Network string  `json:"network"` // network in CIDR notation
+	Rate    float64 `json:"rate"`    // packets per second of all sources in the network
+	Burst   int     `json:"burst"`   // burst of packets of all sources in the network
+}
+
+// defaultBurst returns burst of given rate if it is not set
+func defaultBurst(burst int, rate float64) int {
+	if burst == 0 {
+		return int(math.Max(1, math.Ceil(rate)))
+	}
+	return burst
+}
+
+// setRateLimitDefaults assigns default values of rate limits
+func (c *Configuration) setRateLimitDefaults() {
+	r := &c.RateLimit
+	r.Burst = defaultBurst(r.Burst, r.Rate)
+	r.GlobalBurst = defaultBurst(r.GlobalBurst, r.GlobalRate)
+	for i := range r.Subnets {
+		r.Subnets[i].Burst = defaultBurst(r.Subnets[i].Burst, r.Subnets[i].Rate)
+	}
+	if r.MaxSources == 0 {
+		r.MaxSources = 10000
+	}
+	if r.Action == "" {
+		r.Action = "drop"
+	}
+	if r.SampleRate == 0 {
+		r.SampleRate = 100
+	}
+	if r.TopOffenders == 0 {
+		r.TopOffenders = 10
+	}
+}
+
+// validateRateLimit adds all problems of rate limits to given error
+func (c *Configuration) validateRateLimit(verr *ValidationError) {
+	r := c.RateLimit
+	if r.Rate < 0 || r.GlobalRate < 0 {
+		verr.add("rateLimit: rates must not be negative")
+	}
+	if r.Burst < 0 || r.GlobalBurst < 0 {
+		verr.add("rateLimit: bursts must not be negative")
+	}
+	for _, s := range r.Subnets {
+		if _, err := ParseNetwork(s.Network); err != nil {
+			verr.add("rateLimit: subnets: %v", err)
+		}
+		if s.Rate <= 0 || s.Burst < 0 {
+			verr.add("rateLimit: subnet %s: rate must be positive and burst must not be negative", s.Network)
+		}
+	}
+	if r.MaxSources < 0 {
+		verr.add("rateLimit: maxSources %d must be positive", r.MaxSources)
+	}
+	if r.Action != "drop" && r.Action != "sample" {
+		verr.add("rateLimit: action %q must be drop or sample", r.Action)
+	}
+	if r.SampleRate < 1 {
+		verr.add("rateLimit: sampleRate %d must be positive", r.SampleRate)
+	}
+	if r.TopOffenders < 0 {
+		verr.add("rateLimit: topOffenders %d must be positive", r.TopOffenders)
+	}
+}
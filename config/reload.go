@@ -0,0 +1,264 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Change describes modification of single configuration parameter
+type Change struct {
+	Field   string `json:"field"`   // configuration parameter name
+	Old     string `json:"old"`     // old value
+	New     string `json:"new"`     // new value
+	Restart bool   `json:"restart"` // change requires restart to take effect
+}
+
+// String implements Stringer interface
+func (c Change) String() string {
+	s := fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+	if c.Restart {
+		s += " (requires restart)"
+	}
+	return s
+}
+
+// Store keeps current configuration and allows to reload it
+// from the configuration file
+type Store struct {
+	path        string
+	current     atomic.Pointer[Configuration]
+	mu          sync.Mutex
+	subscribers []func(old, cfg *Configuration)
+}
+
+// NewStore loads given configuration file and returns new Store
+func NewStore(path string) (*Store, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{path: path}
+	s.current.Store(cfg)
+	return s, nil
+}
+
+// Get returns current configuration, it should not be modified
+func (s *Store) Get() *Configuration {
+	return s.current.Load()
+}
+
+// Subscribe registers function which is called after every
+// successful reload with old and new configurations
+func (s *Store) Subscribe(fn func(old, cfg *Configuration)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// Reload re-reads and validates configuration file and atomically swaps
+// current configuration. Parameters which require restart keep their
+// old values and are reported as such.
+func (s *Store) Reload() ([]Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg, err := Load(s.path)
+	if err != nil {
+		slog.Error("config reload failed", "file", s.path, "error", err)
+		return nil, err
+	}
+	old := s.current.Load()
+	changes := Diff(old, cfg)
+	keepRestartFields(old, cfg)
+	// parameters kept from old configuration may conflict with new ones
+	if err := cfg.Validate(); err != nil {
+		slog.Error("config reload failed", "file", s.path, "error", err)
+		return nil, err
+	}
+	s.current.Store(cfg)
+	if len(changes) == 0 {
+		slog.Info("config reloaded without changes", "file", s.path)
+	}
+	for _, c := range changes {
+		slog.Info("config reloaded", "file", s.path, "param", c.Field, "old", c.Old, "new", c.New, "restart", c.Restart)
+	}
+	for _, fn := range s.subscribers {
+		fn(old, cfg)
+	}
+	return changes, nil
+}
+
+// Diff returns list of changes between two configurations, values of
+// secret parameters are masked. Parameters of listeners and sinks are
+// compared by their names, e.g. listeners[udp].decoder.
+func Diff(old, cfg *Configuration) []Change {
+	var changes []Change
+	diffValues("", reflect.ValueOf(old).Elem(), reflect.ValueOf(cfg).Elem(), "", false, &changes)
+	return changes
+}
+
+// diffValues appends changes between two values of given parameter,
+// tag is the struct tag of the parameter and restart tells if its parent
+// requires restart
+func diffValues(name string, ov, nv reflect.Value, tag reflect.StructTag, restart bool, changes *[]Change) {
+	if reflect.DeepEqual(ov.Interface(), nv.Interface()) {
+		return
+	}
+	restart = restart || tag.Get("reload") == "restart"
+	secret := tag.Get("secret") == "true"
+	switch {
+	case ov.Kind() == reflect.Struct:
+		for i := 0; i < ov.NumField(); i++ {
+			field := ov.Type().Field(i)
+			diffValues(joinName(name, fieldName(field)), ov.Field(i), nv.Field(i), field.Tag, restart, changes)
+		}
+		return
+	case ov.Kind() == reflect.Ptr && !ov.IsNil() && !nv.IsNil():
+		diffValues(name, ov.Elem(), nv.Elem(), tag, restart, changes)
+		return
+	case isNamedSlice(ov):
+		members := restart || tag.Get("reload") == "members"
+		for i := 0; i < ov.Len(); i++ {
+			item := fmt.Sprintf("%s[%s]", name, itemName(ov.Index(i)))
+			if j := findItem(nv, itemName(ov.Index(i))); j >= 0 {
+				diffValues(item, ov.Index(i), nv.Index(j), "", restart, changes)
+				continue
+			}
+			*changes = append(*changes, Change{Field: item, Old: formatValue(ov.Index(i), secret), Restart: members})
+		}
+		for i := 0; i < nv.Len(); i++ {
+			if findItem(ov, itemName(nv.Index(i))) < 0 {
+				item := fmt.Sprintf("%s[%s]", name, itemName(nv.Index(i)))
+				*changes = append(*changes, Change{Field: item, New: formatValue(nv.Index(i), secret), Restart: members})
+			}
+		}
+		return
+	}
+	*changes = append(*changes, Change{
+		Field:   name,
+		Old:     formatValue(ov, secret),
+		New:     formatValue(nv, secret),
+		Restart: restart,
+	})
+}
+
+// isNamedSlice checks if given value is a slice of structures with Name field
+func isNamedSlice(v reflect.Value) bool {
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Struct {
+		return false
+	}
+	f, ok := v.Type().Elem().FieldByName("Name")
+	return ok && f.Type.Kind() == reflect.String
+}
+
+// itemName returns name of slice item
+func itemName(v reflect.Value) string {
+	return v.FieldByName("Name").String()
+}
+
+// findItem returns index of slice item with given name or -1
+func findItem(v reflect.Value, name string) int {
+	for i := 0; i < v.Len(); i++ {
+		if itemName(v.Index(i)) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// joinName returns name of nested parameter
+func joinName(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// formatValue returns printable parameter value, structures, slices and
+// maps are formatted as JSON with secrets masked
+func formatValue(v reflect.Value, secret bool) string {
+	switch v.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Map, reflect.Ptr:
+		data, err := json.Marshal(v.Interface())
+		if err != nil {
+			return err.Error()
+		}
+		c := reflect.New(v.Type())
+		if err := json.Unmarshal(data, c.Interface()); err == nil {
+			maskSecrets(c.Elem())
+			if secret {
+				maskValue(c.Elem())
+			}
+			data, _ = json.Marshal(c.Interface())
+		}
+		return string(data)
+	}
+	s := fmt.Sprintf("%v", v.Interface())
+	if secret {
+		return mask(s)
+	}
+	return s
+}
+
+// keepRestartFields copies parameters which require restart from old
+// configuration into new one. Listeners can't be added or removed
+// without restart, the running ones keep their old addresses.
+func keepRestartFields(old, cfg *Configuration) {
+	keepValues(reflect.ValueOf(old).Elem(), reflect.ValueOf(cfg).Elem(), "")
+}
+
+// keepValues copies old values of parameters which require restart
+// into new value
+func keepValues(ov, nv reflect.Value, tag string) {
+	switch {
+	case tag == "restart":
+		nv.Set(ov)
+	case ov.Kind() == reflect.Struct:
+		for i := 0; i < ov.NumField(); i++ {
+			keepValues(ov.Field(i), nv.Field(i), ov.Type().Field(i).Tag.Get("reload"))
+		}
+	case ov.Kind() == reflect.Ptr && !ov.IsNil() && !nv.IsNil():
+		keepValues(ov.Elem(), nv.Elem(), "")
+	case isNamedSlice(ov):
+		items := reflect.MakeSlice(ov.Type(), 0, ov.Len())
+		for i := 0; i < ov.Len(); i++ {
+			j := findItem(nv, itemName(ov.Index(i)))
+			if j >= 0 {
+				keepValues(ov.Index(i), nv.Index(j), "")
+				items = reflect.Append(items, nv.Index(j))
+			} else if tag == "members" {
+				items = reflect.Append(items, ov.Index(i))
+			}
+		}
+		if tag != "members" {
+			// new items don't require restart
+			for i := 0; i < nv.Len(); i++ {
+				if findItem(ov, itemName(nv.Index(i))) < 0 {
+					items = reflect.Append(items, nv.Index(i))
+				}
+			}
+		}
+		nv.Set(items)
+	}
+}
+
+// fieldName returns configuration parameter name from its json tag
+func fieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// mask hides secret value
+func mask(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "***"
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testConfig returns configuration with one listener and one sink
+func testConfig() *Configuration {
+	return &Configuration{
+		Port:          9331,
+		LogLevel:      "info",
+		StompPassword: "secret",
+		Listeners: []Listener{
+			{Name: "udp", Protocol: "udp", Address: ":9331", Decoder: "json"},
+		},
+		Sinks: []Sink{
+			{Name: "amq", Type: "amqp", AMQP: AMQPSink{URL: "amqp://broker:5672", Password: "secret"}},
+		},
+	}
+}
+
+// TestDiff checks reported changes of top-level, nested and named
+// parameters
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Configuration)
+		want   []Change
+	}{
+		{"no changes", func(c *Configuration) {}, nil},
+		{"parameter", func(c *Configuration) { c.LogLevel = "debug" },
+			[]Change{{Field: "logLevel", Old: "info", New: "debug"}}},
+		{"restart parameter", func(c *Configuration) { c.Port = 9332 },
+			[]Change{{Field: "port", Old: "9331", New: "9332", Restart: true}}},
+		{"secret", func(c *Configuration) { c.StompPassword = "changed" },
+			[]Change{{Field: "stompPassword", Old: "***", New: "***"}}},
+		{"listener parameter", func(c *Configuration) { c.Listeners[0].Decoder = "cbor" },
+			[]Change{{Field: "listeners[udp].decoder", Old: "json", New: "cbor"}}},
+		{"listener restart parameter", func(c *Configuration) { c.Listeners[0].Address = ":9332" },
+			[]Change{{Field: "listeners[udp].address", Old: ":9331", New: ":9332", Restart: true}}},
+		{"sink secret", func(c *Configuration) { c.Sinks[0].AMQP.Password = "changed" },
+			[]Change{{Field: "sinks[amq].amqp.password", Old: "***", New: "***"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, cfg := testConfig(), testConfig()
+			tt.modify(cfg)
+			if got := Diff(old, cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiffMembers checks changes of added and removed listeners and sinks,
+// only listeners require restart and secrets of sinks are masked
+func TestDiffMembers(t *testing.T) {
+	old, cfg := testConfig(), testConfig()
+	cfg.Listeners = append(cfg.Listeners, Listener{Name: "tcp", Protocol: "tcp"})
+	cfg.Sinks = []Sink{{Name: "file", Type: "file"}}
+	changes := Diff(old, cfg)
+	got := make(map[string]Change)
+	for _, c := range changes {
+		got[c.Field] = c
+	}
+	if len(changes) != 3 {
+		t.Fatalf("got changes %v, want 3", changes)
+	}
+	if c := got["listeners[tcp]"]; !c.Restart || c.Old != "" || !strings.Contains(c.New, `"protocol":"tcp"`) {
+		t.Errorf("unexpected change of added listener %v", c)
+	}
+	if c := got["sinks[amq]"]; c.Restart || c.New != "" || strings.Contains(c.Old, `"password":"secret"`) {
+		t.Errorf("unexpected change of removed sink %v", c)
+	}
+	if c := got["sinks[file]"]; c.Restart || c.Old != "" || c.New == "" {
+		t.Errorf("unexpected change of added sink %v", c)
+	}
+}
+
+// TestKeepRestartFields checks that parameters which require restart and
+// the set of listeners keep their old values
+func TestKeepRestartFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Configuration)
+		want   func(c *Configuration)
+	}{
+		{"parameter", func(c *Configuration) { c.LogLevel = "debug" },
+			func(c *Configuration) { c.LogLevel = "debug" }},
+		{"restart parameter", func(c *Configuration) { c.Port = 9332 },
+			func(c *Configuration) {}},
+		{"listener parameters", func(c *Configuration) {
+			c.Listeners[0].Address = ":9332"
+			c.Listeners[0].Decoder = "cbor"
+		}, func(c *Configuration) { c.Listeners[0].Decoder = "cbor" }},
+		{"added listener", func(c *Configuration) {
+			c.Listeners = append(c.Listeners, Listener{Name: "tcp", Protocol: "tcp"})
+		}, func(c *Configuration) {}},
+		{"removed listener", func(c *Configuration) { c.Listeners = nil },
+			func(c *Configuration) {}},
+		{"added and removed sinks", func(c *Configuration) {
+			c.Sinks = []Sink{{Name: "file", Type: "file"}}
+		}, func(c *Configuration) { c.Sinks = []Sink{{Name: "file", Type: "file"}} }},
+		{"sink parameter", func(c *Configuration) { c.Sinks[0].AMQP.URL = "amqp://other:5672" },
+			func(c *Configuration) { c.Sinks[0].AMQP.URL = "amqp://other:5672" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, cfg, want := testConfig(), testConfig(), testConfig()
+			tt.modify(cfg)
+			tt.want(want)
+			keepRestartFields(old, cfg)
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("got %v, want %v", cfg, want)
+			}
+		})
+	}
+}
+
+// TestReloadValidatesKeptFields checks that reload is rejected if
+// parameters kept until restart conflict with new ones
+func TestReloadValidatesKeptFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"listeners": [{"name": "ingest", "protocol": "udp", "address": ":9331"}]}`)
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := store.Get()
+
+	// protocol change requires restart, but token is accepted by http only
+	write(`{"listeners": [{"name": "ingest", "protocol": "http", "address": ":9331", "token": "secret"}]}`)
+	if _, err := store.Reload(); err == nil || !strings.Contains(err.Error(), "token is supported by http listeners only") {
+		t.Errorf("got error %v, want token error", err)
+	}
+	if store.Get() != old {
+		t.Error("configuration is replaced by invalid one")
+	}
+
+	write(`{"logLevel": "debug", "listeners": [{"name": "ingest", "protocol": "udp", "address": ":9331"}]}`)
+	if _, err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if store.Get().LogLevel != "debug" {
+		t.Errorf("got logLevel %s, want debug", store.Get().LogLevel)
+	}
+}
@@ -0,0 +1,566 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// Sink describes destination of received records
+type Sink struct {
+	Name       string         `json:"name"`       // sink name used in routing, logs and metrics
+	Type       string         `json:"type"`       // sink type: stomp, amqp, file, kafka, opensearch, webhook or nats
+	Encoding   string         `json:"encoding"`   // encoding of records: json, msgpack or cbor
+	Stomp      StompSink      `json:"stomp"`      // StompAMQ sink parameters
+	AMQP       AMQPSink       `json:"amqp"`       // AMQP 1.0 sink parameters
+	File       FileSink       `json:"file"`       // JSONL file sink parameters
+	Kafka      KafkaSink      `json:"kafka"`      // Kafka sink parameters
+	OpenSearch OpenSearchSink `json:"opensearch"` // OpenSearch bulk API sink parameters
+	Webhook    WebhookSink    `json:"webhook"`    // HTTP webhook sink parameters
+	NATS       NATSSink       `json:"nats"`       // NATS sink parameters
+}
+
+// StompSink describes StompAMQ sink parameters
+type StompSink struct {
+	URI                  string  `json:"uri"`                    // StompAMQ URI
+	Login                string  `json:"login"`                  // StompAQM login name
+	Password             string  `json:"password" secret:"true"` // StompAQM password
+	LoginFile            string  `json:"loginFile"`              // file with StompAQM login name
+	PasswordFile         string  `json:"passwordFile"`           // file with StompAQM password
+	Endpoint             string  `json:"endpoint"`               // StompAMQ endpoint
+	ContentType          string  `json:"contentType"`            // content type of sent messages
+	Iterations           int     `json:"iterations"`             // Stomp iterations
+	SendTimeout          int     `json:"sendTimeout"`            // heartbeat send timeout in seconds
+	RecvTimeout          int     `json:"recvTimeout"`            // heartbeat recv timeout in seconds
+	HeartBeatGracePeriod float64 `json:"heartBeatGracePeriod"`   // is used to calculate the read heart-beat timeout
+}
+
+// AMQPSink describes AMQP 1.0 sink parameters
+type AMQPSink struct {
+	URL           string     `json:"url"`                    // broker URL, amqp://host:5672 or amqps://host:5671
+	Address       string     `json:"address"`                // target address with record fields in braces, top-level endpoint if not set
+	Settlement    string     `json:"settlement"`             // delivery settlement: unsettled to wait for broker disposition or settled
+	Durable       bool       `json:"durable"`                // send durable messages
+	IDField       string     `json:"idField"`                // record field used as message ID
+	SendTimeout   int        `json:"sendTimeout"`            // time to wait for link credit and transfer in seconds
+	AckTimeout    int        `json:"ackTimeout"`             // time to wait for disposition of unsettled records in seconds
+	MaxInflight   int        `json:"maxInflight"`            // maximum number of unsettled records
+	Retries       int        `json:"retries"`                // number of retries of released or unsettled records
+	ReconnectWait int        `json:"reconnectWait"`          // initial delay between reconnect attempts in seconds, doubled up to a minute
+	Mechanism     string     `json:"mechanism"`              // SASL mechanism: plain, anonymous or external
+	Username      string     `json:"username"`               // SASL user name, top-level stompLogin if not set
+	Password      string     `json:"password" secret:"true"` // SASL password, top-level stompPassword if not set
+	PasswordFile  string     `json:"passwordFile"`           // file with SASL password
+	TLS           *ClientTLS `json:"tls"`                    // TLS parameters of amqps URL
+}
+
+// FileSink describes rotating JSONL file sink parameters
+type FileSink struct {
+	Path         string `json:"path"`         // file name pattern with strftime conversions, e.g. /data/records-%Y%m%d%H
+	RotationTime int    `json:"rotationTime"` // rotation interval in seconds
+	RotationSize int    `json:"rotationSize"` // maximum file size in bytes, no size rotation if 0
+	MaxAge       int    `json:"maxAge"`       // maximum age of files in seconds
+	MaxFiles     int    `json:"maxFiles"`     // maximum number of files, can't be used with maxAge
+	Compress     bool   `json:"compress"`     // gzip closed files
+}
+
+// KafkaSink describes Kafka producer sink parameters
+type KafkaSink struct {
+	Brokers      []string    `json:"brokers"`      // bootstrap brokers, host:port
+	Topic        string      `json:"topic"`        // topic to produce records to
+	KeyField     string      `json:"keyField"`     // record field used as partition key, round-robin if not set
+	BatchSize    int         `json:"batchSize"`    // maximum number of records per batch
+	BatchTimeout int         `json:"batchTimeout"` // maximum time to fill a batch in milliseconds
+	Compression  string      `json:"compression"`  // batch compression: none, gzip, snappy, lz4 or zstd
+	Acks         string      `json:"acks"`         // required acknowledgements: none, one or all
+	WriteTimeout int         `json:"writeTimeout"` // write timeout in seconds
+	SASL         *SASLConfig `json:"sasl"`         // SASL authentication, none if not set
+	TLS          *ClientTLS  `json:"tls"`          // TLS parameters, plain connection if not set
+}
+
+// OpenSearchSink describes OpenSearch or Elasticsearch bulk API sink parameters
+type OpenSearchSink struct {
+	URL           string     `json:"url"`                    // cluster URL, e.g. https://es-cms.cern.ch:9203
+	Index         string     `json:"index"`                  // index name pattern with strftime conversions, e.g. cmssw-udp-%Y.%m.%d
+	TimeField     string     `json:"timeField"`              // record field with unix time in seconds used in index name, current time if not set
+	IDField       string     `json:"idField"`                // record field used as document ID, generated IDs if not set
+	BatchSize     int        `json:"batchSize"`              // maximum number of records per bulk request
+	FlushInterval int        `json:"flushInterval"`          // maximum time to fill a batch in milliseconds
+	Timeout       int        `json:"timeout"`                // bulk request timeout in seconds
+	Retries       int        `json:"retries"`                // number of retries of rejected bulk requests and items
+	RetryBackoff  int        `json:"retryBackoff"`           // initial delay between retries in milliseconds, doubled on every retry
+	Username      string     `json:"username"`               // basic auth user name
+	Password      string     `json:"password" secret:"true"` // basic auth password
+	PasswordFile  string     `json:"passwordFile"`           // file with basic auth password
+	Token         string     `json:"token" secret:"true"`    // bearer token
+	TokenFile     string     `json:"tokenFile"`              // file with bearer token
+	TLS           *ClientTLS `json:"tls"`                    // TLS parameters of https URL
+}
+
+// WebhookSink describes generic HTTP sink parameters
+type WebhookSink struct {
+	URL           string            `json:"url"`                         // URL to send records to
+	Method        string            `json:"method"`                      // HTTP method
+	Headers       map[string]string `json:"headers"`                     // request headers
+	SecretHeaders map[string]string `json:"secretHeaders" secret:"true"` // request headers with credentials, e.g. Authorization
+	Body          string            `json:"body"`                        // request body: raw, envelope or template
+	Template      string            `json:"template"`                    // Go template of request body
+	BatchSize     int               `json:"batchSize"`                   // maximum number of records per request
+	FlushInterval int               `json:"flushInterval"`               // maximum time to fill a batch in milliseconds
+	Timeout       int               `json:"timeout"`                     // request timeout in seconds
+	Retries       int               `json:"retries"`                     // number of retries of failed requests
+	RetryBackoff  int               `json:"retryBackoff"`                // initial delay between retries in milliseconds, doubled on every retry
+	SuccessCodes  []int             `json:"successCodes"`                // response codes of delivered requests, any 2xx if empty
+	TLS           *ClientTLS        `json:"tls"`                         // TLS parameters of https URL
+}
+
+// NATSSink describes NATS and NATS JetStream sink parameters
+type NATSSink struct {
+	URL              string     `json:"url"`                    // comma separated server URLs, e.g. nats://nats1:4222,nats://nats2:4222
+	Subject          string     `json:"subject"`                // subject with record fields in braces, e.g. cmssw.udp.{site_name}
+	JetStream        bool       `json:"jetStream"`              // publish to JetStream stream and wait for acknowledgements
+	IDField          string     `json:"idField"`                // record field used as JetStream message ID for deduplication
+	AckTimeout       int        `json:"ackTimeout"`             // JetStream acknowledgement timeout in seconds
+	MaxPending       int        `json:"maxPending"`             // maximum number of unacknowledged JetStream messages
+	Retries          int        `json:"retries"`                // number of retries of unacknowledged JetStream messages
+	ReconnectWait    int        `json:"reconnectWait"`          // delay between reconnect attempts in seconds
+	ReconnectBufSize int        `json:"reconnectBufSize"`       // size of buffer of messages published while reconnecting in bytes
+	Username         string     `json:"username"`               // user name
+	Password         string     `json:"password" secret:"true"` // password
+	PasswordFile     string     `json:"passwordFile"`           // file with password
+	Token            string     `json:"token" secret:"true"`    // authentication token
+	TokenFile        string     `json:"tokenFile"`              // file with authentication token
+	CredsFile        string     `json:"credsFile"`              // file with user JWT and NKey seed
+	TLS              *ClientTLS `json:"tls"`                    // TLS parameters, plain connection if not set
+}
+
+// SASLConfig describes SASL authentication of sink connections
+type SASLConfig struct {
+	Mechanism    string `json:"mechanism"`              // SASL mechanism: plain, scram-sha-256 or scram-sha-512
+	Username     string `json:"username"`               // user name
+	Password     string `json:"password" secret:"true"` // password
+	PasswordFile string `json:"passwordFile"`           // file with password
+}
+
+// ClientTLS describes TLS parameters of sink connections
+type ClientTLS struct {
+	CAFile             string `json:"caFile"`             // CA file to verify server certificate, system CAs if not set
+	CertFile           string `json:"certFile"`           // client certificate file, no client authentication if not set
+	KeyFile            string `json:"keyFile"`            // client key file
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"` // do not verify server certificate
+}
+
+// setSinkDefaults creates default StompAMQ sink from top-level parameters
+// if no sinks are configured and assigns defaults to all sinks
+func (c *Configuration) setSinkDefaults() {
+	if len(c.Sinks) == 0 && c.Endpoint != "" {
+		c.Sinks = []Sink{{
+			Name:     "stomp",
+			Type:     "stomp",
+			Encoding: c.Encoding,
+			Stomp: StompSink{
+				URI:                  c.StompURI,
+				Login:                c.StompLogin,
+				Password:             c.StompPassword,
+				Endpoint:             c.Endpoint,
+				ContentType:          c.ContentType,
+				Iterations:           c.StompIterations,
+				SendTimeout:          c.SendTimeout,
+				RecvTimeout:          c.RecvTimeout,
+				HeartBeatGracePeriod: c.HeartBeatGracePeriod,
+			},
+		}}
+	}
+	for i := range c.Sinks {
+		s := &c.Sinks[i]
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("sink%d", i)
+		}
+		if s.Encoding == "" {
+			s.Encoding = "json"
+		}
+		if s.Type == "amqp" {
+			a := &s.AMQP
+			if a.Address == "" {
+				a.Address = c.Endpoint
+			}
+			if a.Username == "" && a.Password == "" && (a.Mechanism == "" || a.Mechanism == "plain") {
+				a.Username, a.Password = c.StompLogin, c.StompPassword
+			}
+			if a.Mechanism == "" {
+				a.Mechanism = "anonymous"
+				if a.Username != "" {
+					a.Mechanism = "plain"
+				}
+			}
+			if a.Settlement == "" {
+				a.Settlement = "unsettled"
+			}
+			if a.SendTimeout == 0 {
+				a.SendTimeout = 10 // in seconds
+			}
+			if a.AckTimeout == 0 {
+				a.AckTimeout = 30 // in seconds
+			}
+			if a.MaxInflight == 0 {
+				a.MaxInflight = 1000
+			}
+			if a.Retries == 0 {
+				a.Retries = 3
+			}
+			if a.ReconnectWait == 0 {
+				a.ReconnectWait = 1 // in seconds
+			}
+		}
+		if s.Type == "file" {
+			f := &s.File
+			if f.RotationTime == 0 {
+				f.RotationTime = 3600 // in seconds
+			}
+			if f.MaxAge == 0 && f.MaxFiles == 0 {
+				f.MaxAge = 7 * 24 * 3600 // in seconds
+			}
+		}
+		if s.Type == "kafka" {
+			k := &s.Kafka
+			if k.BatchSize == 0 {
+				k.BatchSize = 100
+			}
+			if k.BatchTimeout == 0 {
+				k.BatchTimeout = 1000 // in milliseconds
+			}
+			if k.Compression == "" {
+				k.Compression = "none"
+			}
+			if k.Acks == "" {
+				k.Acks = "all"
+			}
+			if k.WriteTimeout == 0 {
+				k.WriteTimeout = 10 // in seconds
+			}
+		}
+		if s.Type == "opensearch" {
+			o := &s.OpenSearch
+			if o.BatchSize == 0 {
+				o.BatchSize = 500
+			}
+			if o.FlushInterval == 0 {
+				o.FlushInterval = 1000 // in milliseconds
+			}
+			if o.Timeout == 0 {
+				o.Timeout = 30 // in seconds
+			}
+			if o.Retries == 0 {
+				o.Retries = 3
+			}
+			if o.RetryBackoff == 0 {
+				o.RetryBackoff = 500 // in milliseconds
+			}
+		}
+		if s.Type == "webhook" {
+			w := &s.Webhook
+			if w.Method == "" {
+				w.Method = "POST"
+			}
+			if w.Body == "" {
+				w.Body = "raw"
+			}
+			if w.BatchSize == 0 {
+				w.BatchSize = 1
+			}
+			if w.FlushInterval == 0 {
+				w.FlushInterval = 1000 // in milliseconds
+			}
+			if w.Timeout == 0 {
+				w.Timeout = 10 // in seconds
+			}
+			if w.Retries == 0 {
+				w.Retries = 3
+			}
+			if w.RetryBackoff == 0 {
+				w.RetryBackoff = 500 // in milliseconds
+			}
+		}
+		if s.Type == "nats" {
+			n := &s.NATS
+			if n.AckTimeout == 0 {
+				n.AckTimeout = 5 // in seconds
+			}
+			if n.MaxPending == 0 {
+				n.MaxPending = 1000
+			}
+			if n.Retries == 0 {
+				n.Retries = 3
+			}
+			if n.ReconnectWait == 0 {
+				n.ReconnectWait = 2 // in seconds
+			}
+			if n.ReconnectBufSize == 0 {
+				n.ReconnectBufSize = 8 * 1024 * 1024 // 8 MBytes
+			}
+		}
+		if s.Type == "stomp" {
+			st := &s.Stomp
+			if st.ContentType == "" {
+				st.ContentType = "application/" + s.Encoding
+			}
+			if st.Iterations == 0 {
+				st.Iterations = 3 // number of Stomp attempts
+			}
+			if st.HeartBeatGracePeriod == 0 {
+				st.HeartBeatGracePeriod = 1
+			}
+			if st.SendTimeout == 0 {
+				st.SendTimeout = 600 // in seconds
+			}
+		}
+	}
+}
+
+// defaultStompSink checks if given sink is the default one created from
+// top-level parameters
+func (c *Configuration) defaultStompSink(s Sink) bool {
+	return len(c.Sinks) == 1 && s.Name == "stomp" && s.Type == "stomp" &&
+		s.Stomp.URI == c.StompURI && s.Stomp.Login == c.StompLogin && s.Stomp.Endpoint == c.Endpoint
+}
+
+// validateSinks adds all problems of sinks to given error
+func (c *Configuration) validateSinks(verr *ValidationError) {
+	names := make(map[string]bool)
+	for _, s := range c.Sinks {
+		if names[s.Name] {
+			verr.add("sink %s: duplicate name", s.Name)
+		}
+		names[s.Name] = true
+		if !validEncoding(s.Encoding) {
+			verr.add("sink %s: encoding %q must be json, msgpack or cbor", s.Name, s.Encoding)
+		}
+		switch s.Type {
+		case "stomp":
+			st := s.Stomp
+			var missing []string
+			for _, p := range []struct{ name, value string }{{"uri", st.URI}, {"login", st.Login}, {"password", st.Password}} {
+				if p.value == "" {
+					missing = append(missing, p.name)
+				}
+			}
+			if len(missing) > 0 && c.defaultStompSink(s) {
+				// the collector used to start without credentials and
+				// report failed connections
+				slog.Warn("default stomp sink can't connect without parameters", "sink", s.Name, "missing", missing)
+			} else {
+				for _, name := range missing {
+					verr.add("sink %s: stomp %s is required", s.Name, name)
+				}
+			}
+			if st.Endpoint == "" {
+				verr.add("sink %s: stomp endpoint is required", s.Name)
+			}
+			if st.Iterations < 0 {
+				verr.add("sink %s: stomp iterations %d must be positive", s.Name, st.Iterations)
+			}
+			if st.SendTimeout < 0 || st.RecvTimeout < 0 || st.HeartBeatGracePeriod < 0 {
+				verr.add("sink %s: stomp heartbeat parameters must not be negative", s.Name)
+			}
+		case "amqp":
+			a := s.AMQP
+			if u, err := url.Parse(a.URL); err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps") || u.Host == "" {
+				verr.add("sink %s: amqp url %q must be amqp or amqps URL", s.Name, a.URL)
+			}
+			if a.Address == "" {
+				verr.add("sink %s: amqp address is required", s.Name)
+			} else if err := ValidateFieldTemplate(a.Address); err != nil {
+				verr.add("sink %s: invalid amqp address %q: %v", s.Name, a.Address, err)
+			}
+			if a.Settlement != "unsettled" && a.Settlement != "settled" {
+				verr.add("sink %s: amqp settlement %q must be unsettled or settled", s.Name, a.Settlement)
+			}
+			if !slices.Contains([]string{"plain", "anonymous", "external"}, a.Mechanism) {
+				verr.add("sink %s: amqp mechanism %q must be plain, anonymous or external", s.Name, a.Mechanism)
+			}
+			if a.Mechanism == "plain" && (a.Username == "" || a.Password == "") {
+				verr.add("sink %s: amqp username and password are required by plain mechanism", s.Name)
+			}
+			if a.SendTimeout < 0 || a.AckTimeout < 0 || a.MaxInflight < 0 || a.Retries < 0 || a.ReconnectWait < 0 {
+				verr.add("sink %s: amqp timeout, inflight, retry and reconnect parameters must not be negative", s.Name)
+			}
+			validateClientTLS(s.Name, a.TLS, verr)
+		case "file":
+			f := s.File
+			if s.Encoding != "json" {
+				verr.add("sink %s: file sink supports json encoding only", s.Name)
+			}
+			if f.Path == "" {
+				verr.add("sink %s: file path is required", s.Name)
+			} else if !strings.Contains(f.Path, "%") {
+				verr.add("sink %s: file path %q must contain time conversions, e.g. %%Y%%m%%d", s.Name, f.Path)
+			}
+			if f.RotationTime <= 0 || f.RotationSize < 0 {
+				verr.add("sink %s: file rotationTime must be positive and rotationSize must not be negative", s.Name)
+			}
+			if f.MaxAge < 0 || f.MaxFiles < 0 {
+				verr.add("sink %s: file maxAge and maxFiles must not be negative", s.Name)
+			}
+			if f.MaxAge > 0 && f.MaxFiles > 0 {
+				verr.add("sink %s: file maxAge and maxFiles can't be used together", s.Name)
+			}
+		case "kafka":
+			k := s.Kafka
+			if len(k.Brokers) == 0 {
+				verr.add("sink %s: kafka brokers are required", s.Name)
+			}
+			if k.Topic == "" {
+				verr.add("sink %s: kafka topic is required", s.Name)
+			}
+			if k.BatchSize < 0 || k.BatchTimeout < 0 || k.WriteTimeout < 0 {
+				verr.add("sink %s: kafka batchSize, batchTimeout and writeTimeout must not be negative", s.Name)
+			}
+			if !slices.Contains([]string{"none", "gzip", "snappy", "lz4", "zstd"}, k.Compression) {
+				verr.add("sink %s: kafka compression %q must be none, gzip, snappy, lz4 or zstd", s.Name, k.Compression)
+			}
+			if !slices.Contains([]string{"none", "one", "all"}, k.Acks) {
+				verr.add("sink %s: kafka acks %q must be none, one or all", s.Name, k.Acks)
+			}
+			validateSASL(s.Name, k.SASL, verr)
+			validateClientTLS(s.Name, k.TLS, verr)
+		case "opensearch":
+			o := s.OpenSearch
+			if s.Encoding != "json" {
+				verr.add("sink %s: opensearch sink supports json encoding only", s.Name)
+			}
+			if u, err := url.Parse(o.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				verr.add("sink %s: opensearch url %q must be http or https URL", s.Name, o.URL)
+			}
+			if o.Index == "" {
+				verr.add("sink %s: opensearch index is required", s.Name)
+			}
+			if o.BatchSize < 0 || o.FlushInterval < 0 || o.Timeout < 0 || o.Retries < 0 || o.RetryBackoff < 0 {
+				verr.add("sink %s: opensearch batch, timeout and retry parameters must not be negative", s.Name)
+			}
+			if o.Token != "" && o.Username != "" {
+				verr.add("sink %s: opensearch token and username can't be used together", s.Name)
+			}
+			validateClientTLS(s.Name, o.TLS, verr)
+		case "webhook":
+			w := s.Webhook
+			if s.Encoding != "json" {
+				verr.add("sink %s: webhook sink supports json encoding only", s.Name)
+			}
+			if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				verr.add("sink %s: webhook url %q must be http or https URL", s.Name, w.URL)
+			}
+			if !slices.Contains([]string{"POST", "PUT", "PATCH"}, w.Method) {
+				verr.add("sink %s: webhook method %q must be POST, PUT or PATCH", s.Name, w.Method)
+			}
+			if !slices.Contains([]string{"raw", "envelope", "template"}, w.Body) {
+				verr.add("sink %s: webhook body %q must be raw, envelope or template", s.Name, w.Body)
+			}
+			if (w.Body == "template") != (w.Template != "") {
+				verr.add("sink %s: webhook template must be set with template body only", s.Name)
+			} else if w.Template != "" {
+				if _, err := w.ParseTemplate(s.Name); err != nil {
+					verr.add("sink %s: invalid webhook template: %v", s.Name, err)
+				}
+			}
+			if w.BatchSize < 0 || w.FlushInterval < 0 || w.Timeout < 0 || w.Retries < 0 || w.RetryBackoff < 0 {
+				verr.add("sink %s: webhook batch, timeout and retry parameters must not be negative", s.Name)
+			}
+			for _, code := range w.SuccessCodes {
+				if code < 100 || code > 599 {
+					verr.add("sink %s: webhook success code %d is not HTTP status", s.Name, code)
+				}
+			}
+			validateClientTLS(s.Name, w.TLS, verr)
+		case "nats":
+			n := s.NATS
+			if n.URL == "" {
+				verr.add("sink %s: nats url is required", s.Name)
+			}
+			if n.Subject == "" {
+				verr.add("sink %s: nats subject is required", s.Name)
+			} else if err := ValidateFieldTemplate(n.Subject); err != nil {
+				verr.add("sink %s: invalid nats subject %q: %v", s.Name, n.Subject, err)
+			}
+			if n.IDField != "" && !n.JetStream {
+				verr.add("sink %s: nats idField requires jetStream", s.Name)
+			}
+			if n.AckTimeout < 0 || n.MaxPending < 0 || n.Retries < 0 || n.ReconnectWait < 0 || n.ReconnectBufSize < 0 {
+				verr.add("sink %s: nats timeout, pending, retry and reconnect parameters must not be negative", s.Name)
+			}
+			if (n.Username != "") != (n.Password != "") {
+				verr.add("sink %s: nats username and password must be set together", s.Name)
+			}
+			var auths int
+			for _, set := range []bool{n.Username != "", n.Token != "", n.CredsFile != ""} {
+				if set {
+					auths++
+				}
+			}
+			if auths > 1 {
+				verr.add("sink %s: nats username, token and credsFile can't be used together", s.Name)
+			}
+			validateClientTLS(s.Name, n.TLS, verr)
+		default:
+			verr.add("sink %s: unsupported type %q", s.Name, s.Type)
+		}
+	}
+}
+
+// validateSASL adds problems of SASL parameters of given sink to given error
+func validateSASL(sink string, c *SASLConfig, verr *ValidationError) {
+	if c == nil {
+		return
+	}
+	if !slices.Contains([]string{"plain", "scram-sha-256", "scram-sha-512"}, c.Mechanism) {
+		verr.add("sink %s: sasl mechanism %q must be plain, scram-sha-256 or scram-sha-512", sink, c.Mechanism)
+	}
+	if c.Username == "" || c.Password == "" {
+		verr.add("sink %s: sasl username and password are required", sink)
+	}
+}
+
+// validateClientTLS adds problems of TLS parameters of given sink to given error
+func validateClientTLS(sink string, c *ClientTLS, verr *ValidationError) {
+	if c != nil && (c.CertFile == "") != (c.KeyFile == "") {
+		verr.add("sink %s: tls certFile and keyFile must be set together", sink)
+	}
+}
+
+// ParseTemplate parses body template of webhook sink, the template may use
+// json function to encode values
+func (w *WebhookSink) ParseTemplate(name string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}
+	return template.New(name).Funcs(funcs).Parse(w.Template)
+}
+
+// ValidateFieldTemplate checks template with record fields in braces,
+// e.g. cmssw.udp.{site_name}
+func ValidateFieldTemplate(t string) error {
+	for {
+		start := strings.IndexAny(t, "{}")
+		if start < 0 {
+			return nil
+		}
+		if t[start] == '}' {
+			return fmt.Errorf("unexpected } at %q", t[start:])
+		}
+		end := strings.IndexAny(t[start+1:], "{}")
+		if end < 0 || t[start+1+end] == '{' {
+			return fmt.Errorf("unclosed { at %q", t[start:])
+		}
+		if end == 0 {
+			return errors.New("empty field name")
+		}
+		t = t[start+end+2:]
+	}
+}
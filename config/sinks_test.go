@@ -0,0 +1,33 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestStompCredentials checks that only the default stomp sink may lack
+// credentials
+func TestStompCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Configuration
+		err  string
+	}{
+		{"default sink", Configuration{Endpoint: "/topic/cms"}, ""},
+		{"default sink with uri", Configuration{Endpoint: "/topic/cms", StompURI: "broker:61313"}, ""},
+		{"explicit sink", Configuration{Sinks: []Sink{{Name: "amq", Type: "stomp", Stomp: StompSink{Endpoint: "/topic/cms"}}}},
+			"sink amq: stomp uri is required; sink amq: stomp login is required; sink amq: stomp password is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.SetDefaults()
+			err := tt.cfg.Validate()
+			if tt.err == "" && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("got error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package logging
+
+// logging - structured leveled logging of UDP collector based on log/slog
+//           with optional log rotation and rate-limiting of repeated messages
+//
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+)
+
+// level holds current log level which can be changed at run-time
+var level slog.LevelVar
+
+// ParseLevel converts log level name to slog level
+func ParseLevel(name string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(name))
+	return l, err
+}
+
+// SetLevel sets log level from given configuration, verbose flag
+// always enables debug level
+func SetLevel(cfg *config.Configuration) {
+	l, err := ParseLevel(cfg.LogLevel)
+	if err != nil {
+		l = slog.LevelInfo
+	}
+	if cfg.Verbose {
+		l = slog.LevelDebug
+	}
+	level.Set(l)
+}
+
+// Setup configures default slog logger from given configuration
+func Setup(cfg *config.Configuration) error {
+	var out io.Writer = os.Stderr
+	if cfg.LogFile != "" {
+		logName := cfg.LogFile + "-%Y%m%d"
+		hostname, err := os.Hostname()
+		if err == nil {
+			logName = cfg.LogFile + "-" + hostname + "-%Y%m%d"
+		}
+		rl, err := rotatelogs.New(logName)
+		if err != nil {
+			return fmt.Errorf("unable to setup log rotation: %w", err)
+		}
+		out = rl
+	}
+	SetLevel(cfg)
+	opts := &slog.HandlerOptions{Level: &level}
+	var handler slog.Handler
+	if strings.ToLower(cfg.LogFormat) == "json" {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	if cfg.LogRepeatInterval > 0 {
+		handler = NewRateLimitHandler(handler, time.Duration(cfg.LogRepeatInterval)*time.Second)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// maxRepeats is the maximum number of keys tracked by Limiter
+const maxRepeats = 1000
+
+// repeatState keeps track of repeated log messages
+type repeatState struct {
+	key        string    // key of the message
+	last       time.Time // time when message was emitted last time
+	suppressed int       // number of suppressed messages since then
+}
+
+// Limiter allows one event per key within given interval and counts
+// suppressed events, it is used to rate-limit repeated log messages.
+// Keys are kept in order of their last allowed event, so expired and,
+// above maxRepeats, the oldest keys are removed from the front.
+type Limiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	order    *list.List               // repeat states by time of last allowed event
+	repeats  map[string]*list.Element // elements of order by keys
+}
+
+// NewLimiter returns new Limiter
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{
+		interval: interval,
+		order:    list.New(),
+		repeats:  make(map[string]*list.Element),
+	}
+}
+
+// Allow checks if event with given key is allowed at given time and returns
+// number of events suppressed since the last allowed one
+func (l *Limiter) Allow(key string, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.repeats[key]
+	if ok {
+		state := e.Value.(*repeatState)
+		if now.Sub(state.last) < l.interval {
+			state.suppressed++
+			return false, 0
+		}
+		suppressed := state.suppressed
+		state.last = now
+		state.suppressed = 0
+		l.order.MoveToBack(e)
+		return true, suppressed
+	}
+	l.cleanup(now)
+	l.repeats[key] = l.order.PushBack(&repeatState{key: key, last: now})
+	return true, 0
+}
+
+// cleanup removes expired entries and the oldest ones above maxRepeats,
+// must be called with acquired lock
+func (l *Limiter) cleanup(now time.Time) {
+	for e := l.order.Front(); e != nil; e = l.order.Front() {
+		state := e.Value.(*repeatState)
+		if now.Sub(state.last) < l.interval && l.order.Len() < maxRepeats {
+			return
+		}
+		l.order.Remove(e)
+		delete(l.repeats, state.key)
+	}
+}
+
+// RateLimitHandler wraps slog handler and suppresses repeats of the same
+// warning or error message within given interval. The number of suppressed
+// repeats is reported with next emitted message.
+type RateLimitHandler struct {
+	handler slog.Handler
+	limiter *Limiter
+}
+
+// NewRateLimitHandler returns new RateLimitHandler
+func NewRateLimitHandler(h slog.Handler, interval time.Duration) *RateLimitHandler {
+	return &RateLimitHandler{handler: h, limiter: NewLimiter(interval)}
+}
+
+// Enabled implements slog.Handler interface
+func (h *RateLimitHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.handler.Enabled(ctx, l)
+}
+
+// Handle implements slog.Handler interface
+func (h *RateLimitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.handler.Handle(ctx, r)
+	}
+	key := r.Level.String() + "|" + r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "error" {
+			key += "|" + a.Value.String()
+			return false
+		}
+		return true
+	})
+	ok, suppressed := h.limiter.Allow(key, r.Time)
+	if !ok {
+		return nil
+	}
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler interface
+func (h *RateLimitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &RateLimitHandler{handler: h.handler.WithAttrs(attrs), limiter: h.limiter}
+}
+
+// WithGroup implements slog.Handler interface
+func (h *RateLimitHandler) WithGroup(name string) slog.Handler {
+	return &RateLimitHandler{handler: h.handler.WithGroup(name), limiter: h.limiter}
+}
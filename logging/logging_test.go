@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestLimiter checks suppression of repeated events and reporting of the
+// number of suppressed ones
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(time.Minute)
+	now := time.Now()
+	steps := []struct {
+		key        string
+		after      time.Duration
+		ok         bool
+		suppressed int
+	}{
+		{"a", 0, true, 0},
+		{"a", time.Second, false, 0},
+		{"b", time.Second, true, 0},
+		{"a", 30 * time.Second, false, 0},
+		{"a", time.Minute, true, 2},
+		{"a", time.Minute + time.Second, false, 0},
+		{"b", 2 * time.Minute, true, 0},
+	}
+	for i, s := range steps {
+		ok, suppressed := l.Allow(s.key, now.Add(s.after))
+		if ok != s.ok || suppressed != s.suppressed {
+			t.Errorf("step %d: got %v and %d suppressed, want %v and %d", i, ok, suppressed, s.ok, s.suppressed)
+		}
+	}
+}
+
+// TestLimiterCleanup checks that expired keys are removed and the number
+// of keys never exceeds maxRepeats
+func TestLimiterCleanup(t *testing.T) {
+	l := NewLimiter(time.Minute)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		l.Allow(fmt.Sprintf("old%d", i), now)
+	}
+	now = now.Add(time.Minute)
+	for i := 0; i < 3*maxRepeats; i++ {
+		l.Allow(fmt.Sprintf("key%d", i), now)
+		if len(l.repeats) > maxRepeats || l.order.Len() != len(l.repeats) {
+			t.Fatalf("got %d keys and %d ordered, want at most %d", len(l.repeats), l.order.Len(), maxRepeats)
+		}
+	}
+	if _, ok := l.repeats["old0"]; ok {
+		t.Error("expired key is kept")
+	}
+	if _, ok := l.repeats[fmt.Sprintf("key%d", 3*maxRepeats-1)]; !ok {
+		t.Error("the newest key is evicted")
+	}
+	if ok, _ := l.Allow(fmt.Sprintf("key%d", 3*maxRepeats-1), now); ok {
+		t.Error("repeat of the newest key is allowed")
+	}
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
-	"fmt"
-	"time"
+	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/dmwm/udp-collector/config"
+	"github.com/dmwm/udp-collector/logging"
 	"github.com/dmwm/udp-collector/udpserver"
 	"github.com/dmwm/udp-collector/udpservermonitor"
 )
@@ -17,13 +27,133 @@ var version string
 
 func info() string {
 	goVersion := runtime.Version()
-	tstamp := time.Now().Format("2006-02-01")
+	tstamp := time.Now().Format("2006-01-02")
 	return fmt.Sprintf("UDPServer git=%s go=%s date=%s", version, goVersion, tstamp)
 }
 
+// checkConfig parses and validates configuration file and prints
+// effective configuration with default values applied
+func checkConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	var configFile string
+	fs.StringVar(&configFile, "config", "", "configuration file")
+	fs.Parse(args)
+	if configFile == "" {
+		fmt.Fprintln(os.Stderr, "Usage: udp_collector check-config -config=/path/to/config.json")
+		return 1
+	}
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		var verr *config.ValidationError
+		if errors.As(err, &verr) {
+			fmt.Fprintf(os.Stderr, "%s is invalid:\n", configFile)
+			for _, p := range verr.Problems {
+				fmt.Fprintf(os.Stderr, "  - %s\n", p)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+	data, err := json.MarshalIndent(cfg.Masked(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+// fieldMatches collects field=value filters of replay command
+type fieldMatches map[string]string
+
+// String implements flag.Value interface
+func (m fieldMatches) String() string { return fmt.Sprint(map[string]string(m)) }
+
+// Set implements flag.Value interface
+func (m fieldMatches) Set(s string) error {
+	key, val, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("filter %q must be field=value", s)
+	}
+	m[key] = val
+	return nil
+}
+
+// parseTime parses time given as RFC3339 or unix time in seconds
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// replay re-sends archived records through transformations and sinks
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var configFile, from, to string
+	opts := udpserver.ReplayOptions{Match: make(map[string]string)}
+	fs.StringVar(&configFile, "config", "", "configuration file")
+	fs.StringVar(&opts.Listener, "listener", "", "listener whose transformations and sinks are used, the first one by default")
+	fs.StringVar(&opts.TimeField, "time-field", "end_time", "record field with unix time in seconds")
+	fs.StringVar(&from, "from", "", "replay records since given time, RFC3339 or unix time")
+	fs.StringVar(&to, "to", "", "replay records before given time, RFC3339 or unix time")
+	fs.Var(fieldMatches(opts.Match), "match", "replay records with given field value, field=value, may be repeated")
+	fs.Float64Var(&opts.Rate, "rate", 0, "maximum number of records per second, unlimited if 0")
+	fs.DurationVar(&opts.Progress, "progress", 10*time.Second, "interval of progress reports")
+	fs.StringVar(&opts.OffsetsFile, "offsets", "", "file to keep offsets of replayed files to resume from")
+	fs.Parse(args)
+	opts.Files = fs.Args()
+	if configFile == "" || len(opts.Files) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: udp_collector replay -config=/path/to/config.json [options] file.jsonl [file.jsonl.gz ...]")
+		fs.PrintDefaults()
+		return 1
+	}
+	var err error
+	if opts.From, err = parseTime(from); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -from:", err)
+		return 1
+	}
+	if opts.To, err = parseTime(to); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -to:", err)
+		return 1
+	}
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := logging.Setup(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// stop on interrupt, the offsets are saved to resume later
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if _, err := udpserver.Replay(ctx, cfg, opts); errors.Is(err, context.Canceled) {
+		slog.Warn("replay interrupted", "offsets", opts.OffsetsFile)
+		return 1
+	} else if err != nil {
+		slog.Error("replay failed", "error", err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
-	var config string
-	flag.StringVar(&config, "config", "", "configuration file")
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfig(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+
+	var configFile string
+	flag.StringVar(&configFile, "config", "", "configuration file")
 	var version bool
 	flag.BoolVar(&version, "version", false, "version")
 	flag.Parse()
@@ -33,20 +163,40 @@ func main() {
 		os.Exit(0)
 	}
 
-	if config == "" {
-		log.Println("Usage: udp_collector -config=/path/to/config.json [-version]")
+	if configFile == "" {
+		log.Println("Usage: udp_collector -config=/path/to/config.{json,yaml,toml} [-version]")
+		log.Println("       udp_collector check-config -config=/path/to/config.{json,yaml,toml}")
+		log.Println("       udp_collector replay -config=/path/to/config.{json,yaml,toml} [options] files")
 		os.Exit(1)
 	}
 
+	// load configuration shared by udp server and its monitor
+	store, err := config.NewStore(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := logging.Setup(store.Get()); err != nil {
+		log.Fatal(err)
+	}
+	store.Subscribe(func(_, cfg *config.Configuration) {
+		logging.SetLevel(cfg)
+	})
+
 	// Start the udp server
 	go func() {
-		udpserver.StartServer(config)
+		udpserver.StartServer(store)
 	}()
 
 	// Start the udp server monitor
 	go func() {
-		udpservermonitor.StartMonitor(config)
+		udpservermonitor.StartMonitor(store)
 	}()
 
-	select {}
-}
\ No newline at end of file
+	// reload configuration on SIGHUP
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	for range sighup {
+		slog.Info("received SIGHUP, reloading configuration")
+		store.Reload()
+	}
+}
@@ -9,6 +9,11 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/dmwm/udp-collector/udpserver"
 )
 
 func record(seed, user, host string) map[string]interface{} {
@@ -42,8 +47,27 @@ func record(seed, user, host string) map[string]interface{} {
 	return data
 }
 
-func send(host string, port, ndocs int) {
-	conn, err := net.Dial("udp", fmt.Sprintf("%s:%d", host, port))
+// signer signs packets with a key from keyring file
+type signer struct {
+	keyID     string
+	key       []byte
+	timestamp bool
+}
+
+// sign returns signed packet or unchanged data if signer has no key
+func (s signer) sign(data []byte) []byte {
+	if s.key == nil {
+		return data
+	}
+	var ts int64
+	if s.timestamp {
+		ts = time.Now().Unix()
+	}
+	return udpserver.SignPacket(s.keyID, s.key, ts, data)
+}
+
+func send(host string, port, ndocs int, s signer) {
+	conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port)))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -54,7 +78,7 @@ func send(host string, port, ndocs int) {
 		doc := record(fmt.Sprintf("%d", i), user, host)
 		data, err := json.Marshal(doc)
 		if err == nil {
-			conn.Write(data)
+			conn.Write(s.sign(data))
 		} else {
 			log.Println(err)
 		}
@@ -68,8 +92,22 @@ func main() {
 	flag.IntVar(&port, "port", 9331, "port number")
 	var ndocs int
 	flag.IntVar(&ndocs, "ndocs", 10, "number of docs to generate and send")
+	var keyring string
+	flag.StringVar(&keyring, "keyring", "", "keyring file to sign packets")
+	var s signer
+	flag.StringVar(&s.keyID, "keyid", "", "key ID to sign packets with")
+	flag.BoolVar(&s.timestamp, "timestamp", true, "add timestamp to signed packets")
 	flag.Parse()
 	// log time, filename, and line number
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	send(host, port, ndocs)
+	if keyring != "" {
+		keys, err := config.LoadKeyring(keyring)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if s.key = keys[s.keyID]; s.key == nil {
+			log.Fatalf("key ID %q is not found in %s", s.keyID, keyring)
+		}
+	}
+	send(host, port, ndocs, s)
 }
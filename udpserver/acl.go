@@ -0,0 +1,111 @@
+package udpserver
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// accessList holds allow and deny lists of source networks
+type accessList struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// acl holds access list of current configuration
+var acl atomic.Pointer[accessList]
+
+// updateAccessList replaces access list with the one of given configuration
+func updateAccessList(cfg *config.Configuration) {
+	a := &accessList{}
+	for _, n := range cfg.Allow {
+		if prefix, err := config.ParseNetwork(n); err == nil {
+			a.allow = append(a.allow, prefix)
+		}
+	}
+	for _, n := range cfg.Deny {
+		if prefix, err := config.ParseNetwork(n); err == nil {
+			a.deny = append(a.deny, prefix)
+		}
+	}
+	acl.Store(a)
+}
+
+// maxDeniedNetworks bounds number of source networks missing in allow list
+// which are counted separately
+const maxDeniedNetworks = 1000
+
+// otherNetworks is a network of rejected addresses missing in allow list
+// above maxDeniedNetworks
+const otherNetworks = "other"
+
+// deniedNetworks holds source networks missing in allow list which are
+// counted separately
+var deniedNetworks = struct {
+	sync.Mutex
+	seen map[netip.Prefix]struct{}
+}{seen: make(map[netip.Prefix]struct{})}
+
+// check checks if given address is allowed, otherwise it returns network
+// which rejected the address and true if it is a deny network, the source
+// /24 IPv4 or /48 IPv6 network is returned if address is not in the allow list
+func (a *accessList) check(addr netip.Addr) (netip.Prefix, bool, bool) {
+	addr = addr.Unmap()
+	for _, prefix := range a.deny {
+		if prefix.Contains(addr) {
+			return prefix, true, false
+		}
+	}
+	if len(a.allow) == 0 {
+		return netip.Prefix{}, false, true
+	}
+	for _, prefix := range a.allow {
+		if prefix.Contains(addr) {
+			return netip.Prefix{}, false, true
+		}
+	}
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix, false, false
+}
+
+// networkLabel returns metric label of source network missing in allow
+// list, the networks above maxDeniedNetworks are counted as other
+func networkLabel(prefix netip.Prefix) string {
+	deniedNetworks.Lock()
+	defer deniedNetworks.Unlock()
+	if _, ok := deniedNetworks.seen[prefix]; !ok {
+		if len(deniedNetworks.seen) >= maxDeniedNetworks {
+			return otherNetworks
+		}
+		deniedNetworks.seen[prefix] = struct{}{}
+	}
+	return prefix.String()
+}
+
+// permitted checks if source address of data received by given listener
+// is allowed, the packets of not allowed sources are rejected
+func permitted(cfg *config.Configuration, listener, remote string, addr netip.Addr, data []byte) bool {
+	a := acl.Load()
+	if a == nil {
+		return true
+	}
+	prefix, denied, ok := a.check(addr)
+	if ok {
+		return true
+	}
+	if denied {
+		deniedPackets.WithLabelValues(listener, prefix.String()).Inc()
+		reject(cfg, listener, remote, reasonDenied, data, fmt.Errorf("source network %s is denied", prefix))
+		return false
+	}
+	deniedPackets.WithLabelValues(listener, networkLabel(prefix)).Inc()
+	reject(cfg, listener, remote, reasonDenied, data, fmt.Errorf("source network %s is not in allow list", prefix))
+	return false
+}
@@ -0,0 +1,95 @@
+package udpserver
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestAccessList checks precedence of deny list, network boundaries and
+// networks reported for rejected addresses
+func TestAccessList(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		addr    string
+		ok      bool
+		denied  bool
+		network string
+	}{
+		{"no lists", nil, nil, "192.0.2.1", true, false, ""},
+		{"denied", nil, []string{"192.0.2.0/24"}, "192.0.2.1", false, true, "192.0.2.0/24"},
+		{"not denied", nil, []string{"192.0.2.0/24"}, "192.0.3.1", true, false, ""},
+		{"denied address", nil, []string{"192.0.2.7"}, "192.0.2.7", false, true, "192.0.2.7/32"},
+		{"next to denied address", nil, []string{"192.0.2.7"}, "192.0.2.8", true, false, ""},
+		{"allowed", []string{"188.184.0.0/16"}, nil, "188.184.1.1", true, false, ""},
+		{"last allowed", []string{"188.184.0.0/16"}, nil, "188.184.255.255", true, false, ""},
+		{"after allowed", []string{"188.184.0.0/16"}, nil, "188.185.0.0", false, false, "188.185.0.0/24"},
+		{"deny takes precedence", []string{"188.184.0.0/16"}, []string{"188.184.10.0/24"}, "188.184.10.5", false, true, "188.184.10.0/24"},
+		{"allowed next to denied", []string{"188.184.0.0/16"}, []string{"188.184.10.0/24"}, "188.184.11.5", true, false, ""},
+		{"IPv4-mapped", []string{"188.184.0.0/16"}, nil, "::ffff:188.184.1.1", true, false, ""},
+		{"IPv4-mapped denied", nil, []string{"188.184.0.0/16"}, "::ffff:188.184.1.1", false, true, "188.184.0.0/16"},
+		{"IPv6 allowed", []string{"2001:1458::/32"}, nil, "2001:1458:1::1", true, false, ""},
+		{"IPv6 not allowed", []string{"2001:1458::/32"}, nil, "2001:db8:1:2::1", false, false, "2001:db8:1::/48"},
+		{"IPv6 denied", []string{"2001:1458::/32"}, []string{"2001:1458:1::/48"}, "2001:1458:1::1", false, true, "2001:1458:1::/48"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updateAccessList(&config.Configuration{Allow: tt.allow, Deny: tt.deny})
+			prefix, denied, ok := acl.Load().check(netip.MustParseAddr(tt.addr))
+			if ok != tt.ok || denied != tt.denied {
+				t.Errorf("got ok %v and denied %v, want %v and %v", ok, denied, tt.ok, tt.denied)
+			}
+			if !ok && prefix.String() != tt.network {
+				t.Errorf("got network %s, want %s", prefix, tt.network)
+			}
+		})
+	}
+	acl.Store(nil)
+}
+
+// TestPermittedLabels checks network labels of rejected packets and the
+// bound of source networks missing in allow list
+func TestPermittedLabels(t *testing.T) {
+	cfg := &config.Configuration{Allow: []string{"188.184.0.0/16"}, Deny: []string{"188.184.10.0/24"}, RejectedPayloadSize: 100}
+	updateAccessList(cfg)
+	defer acl.Store(nil)
+	saved := deniedNetworks.seen
+	deniedNetworks.seen = make(map[netip.Prefix]struct{})
+	defer func() { deniedNetworks.seen = saved }()
+
+	listener := "acl-labels"
+	want := map[string]float64{
+		"188.184.10.0/24": 1,
+		"10.0.0.0/24":     2, // known network is counted separately above the bound
+		"10.3.231.0/24":   1,
+		otherNetworks:     10,
+	}
+	before := make(map[string]float64)
+	for network := range want {
+		before[network] = testutil.ToFloat64(deniedPackets.WithLabelValues(listener, network))
+	}
+	if !permitted(cfg, listener, "", netip.MustParseAddr("188.184.1.1"), nil) {
+		t.Error("allowed address is rejected")
+	}
+	if permitted(cfg, listener, "", netip.MustParseAddr("188.184.10.1"), nil) {
+		t.Error("denied address is permitted")
+	}
+	for i := 0; i < maxDeniedNetworks+10; i++ {
+		addr := netip.AddrFrom4([4]byte{10, byte(i >> 8), byte(i), 1})
+		if permitted(cfg, listener, "", addr, nil) {
+			t.Fatalf("address %s missing in allow list is permitted", addr)
+		}
+	}
+	if permitted(cfg, listener, "", netip.MustParseAddr("10.0.0.2"), nil) {
+		t.Error("address missing in allow list is permitted")
+	}
+	for network, n := range want {
+		if got := testutil.ToFloat64(deniedPackets.WithLabelValues(listener, network)) - before[network]; got != n {
+			t.Errorf("network %s: got %v packets, want %v", network, got, n)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package udpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/dmwm/udp-collector/config"
+)
+
+// maxReconnectWait is the maximum delay between reconnect attempts
+const maxReconnectWait = time.Minute
+
+// amqpSink sends records to AMQP 1.0 broker, unsettled records are
+// confirmed asynchronously by broker disposition and retried if broker
+// releases them or connection is lost. Lost connection is reopened in
+// background, records sent meanwhile fail immediately.
+type amqpSink struct {
+	name         string
+	cfg          config.AMQPSink
+	encoder      Encoder
+	mu           sync.Mutex
+	conn         *amqp.Conn
+	session      *amqp.Session
+	senders      map[string]*amqp.Sender // senders by target addresses
+	inflight     chan struct{}           // semaphore of unsettled records
+	wg           sync.WaitGroup
+	reconnecting bool          // connection is being reopened in background
+	closed       bool          // sink is closed, the connection must not be reopened
+	stop         chan struct{} // closed when sink is closed to stop reconnecting
+}
+
+// errAMQPClosed is returned when record is sent by closed sink
+var errAMQPClosed = errors.New("AMQP sink is closed")
+
+// errAMQPDisconnected is returned when record is sent while connection
+// is being reopened
+var errAMQPDisconnected = errors.New("not connected to AMQP broker")
+
+// newAMQPSink returns AMQP sink connected to its broker, the connection
+// is retried in background if broker is unavailable
+func newAMQPSink(cfg config.Sink, encoder Encoder) *amqpSink {
+	s := &amqpSink{
+		name:     cfg.Name,
+		cfg:      cfg.AMQP,
+		encoder:  encoder,
+		inflight: make(chan struct{}, cfg.AMQP.MaxInflight),
+		stop:     make(chan struct{}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg.SendTimeout)*time.Second)
+	defer cancel()
+	conn, session, err := s.connect(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.startReconnect()
+		return s
+	}
+	s.conn, s.session, s.senders = conn, session, make(map[string]*amqp.Sender)
+	return s
+}
+
+// connect opens connection and session to the broker
+func (s *amqpSink) connect(ctx context.Context) (*amqp.Conn, *amqp.Session, error) {
+	opts := &amqp.ConnOptions{ContainerID: "udp-collector-" + s.name + "-" + instanceID}
+	switch s.cfg.Mechanism {
+	case "plain":
+		opts.SASLType = amqp.SASLTypePlain(s.cfg.Username, s.cfg.Password)
+	case "anonymous":
+		opts.SASLType = amqp.SASLTypeAnonymous()
+	case "external":
+		opts.SASLType = amqp.SASLTypeExternal("")
+	}
+	if s.cfg.TLS != nil {
+		tlsConfig, err := clientTLSConfig(s.cfg.TLS)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	conn, err := amqp.Dial(ctx, s.cfg.URL, opts)
+	if err != nil {
+		slog.Error("unable to connect to AMQP broker", "sink", s.name, "url", s.cfg.URL, "error", err)
+		return nil, nil, err
+	}
+	session, err := conn.NewSession(ctx, nil)
+	if err != nil {
+		conn.Close()
+		slog.Error("unable to open AMQP session", "sink", s.name, "url", s.cfg.URL, "error", err)
+		return nil, nil, err
+	}
+	slog.Info("connected to AMQP broker", "sink", s.name, "url", s.cfg.URL, "username", s.cfg.Username)
+	return conn, session, nil
+}
+
+// startReconnect reopens connection in background unless it is already
+// being reopened, must be called with lock held
+func (s *amqpSink) startReconnect() {
+	if s.reconnecting || s.closed {
+		return
+	}
+	s.reconnecting = true
+	go s.reconnect()
+}
+
+// reconnect reopens connection until it succeeds or sink is closed, the
+// delay between attempts is doubled up to maxReconnectWait
+func (s *amqpSink) reconnect() {
+	wait := time.Duration(s.cfg.ReconnectWait) * time.Second
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg.SendTimeout)*time.Second)
+		conn, session, err := s.connect(ctx)
+		cancel()
+		if err == nil {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.reconnecting = false
+			if s.closed {
+				conn.Close()
+				return
+			}
+			s.conn, s.session, s.senders = conn, session, make(map[string]*amqp.Sender)
+			return
+		}
+		wait = min(2*wait, maxReconnectWait)
+	}
+}
+
+// sender returns sender of given address, it fails immediately if sink
+// is not connected
+func (s *amqpSink) sender(ctx context.Context, address string) (*amqp.Sender, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errAMQPClosed
+	}
+	if s.conn == nil {
+		s.startReconnect()
+		return nil, errAMQPDisconnected
+	}
+	if snd, ok := s.senders[address]; ok {
+		return snd, nil
+	}
+	mode := amqp.SenderSettleModeUnsettled
+	if s.cfg.Settlement == "settled" {
+		mode = amqp.SenderSettleModeSettled
+	}
+	snd, err := s.session.NewSender(ctx, address, &amqp.SenderOptions{SettlementMode: &mode})
+	if err != nil {
+		return nil, err
+	}
+	s.senders[address] = snd
+	return snd, nil
+}
+
+// reset closes connection after connection, session or link failure and
+// reopens it in background
+func (s *amqpSink) reset(err error) {
+	var connErr *amqp.ConnError
+	var sessionErr *amqp.SessionError
+	var linkErr *amqp.LinkError
+	if !errors.As(err, &connErr) && !errors.As(err, &sessionErr) && !errors.As(err, &linkErr) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		slog.Warn("closing AMQP connection", "sink", s.name, "url", s.cfg.URL, "error", err)
+		s.conn.Close()
+		s.conn, s.session, s.senders = nil, nil, nil
+		s.startReconnect()
+	}
+}
+
+// Send implements Sink interface
+func (s *amqpSink) Send(rec Record) error {
+	data, err := s.encoder.Encode(rec)
+	if err != nil {
+		return &encodeError{err}
+	}
+	msg := amqp.NewMessage(data)
+	contentType := s.encoder.ContentType()
+	msg.Properties = &amqp.MessageProperties{ContentType: &contentType}
+	if val, ok := rec[s.cfg.IDField]; ok && s.cfg.IDField != "" {
+		msg.Properties.MessageID = fmt.Sprint(val)
+	}
+	msg.Header = &amqp.MessageHeader{Durable: s.cfg.Durable}
+	return s.send(expandFields(s.cfg.Address, rec, fieldToken), msg, 0)
+}
+
+// send transfers message to given address, unsettled messages are
+// settled asynchronously
+func (s *amqpSink) send(address string, msg *amqp.Message, attempt int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg.SendTimeout)*time.Second)
+	defer cancel()
+	snd, err := s.sender(ctx, address)
+	if err == nil && s.cfg.Settlement == "settled" {
+		err = snd.Send(ctx, msg, nil)
+	} else if err == nil {
+		err = s.sendUnsettled(ctx, snd, address, msg, attempt)
+	}
+	if err != nil {
+		s.reset(err)
+		slog.Error("unable to send data", "sink", s.name, "address", address, "bytes", len(msg.GetData()), "attempt", attempt, "error", err)
+	}
+	return err
+}
+
+// sendUnsettled transfers message when link has credit and waits for its
+// disposition in background
+func (s *amqpSink) sendUnsettled(ctx context.Context, snd *amqp.Sender, address string, msg *amqp.Message, attempt int) error {
+	select {
+	case s.inflight <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("too many unsettled records: %w", ctx.Err())
+	}
+	receipt, err := snd.SendWithReceipt(ctx, msg, nil)
+	if err != nil {
+		<-s.inflight
+		return err
+	}
+	s.wg.Add(1)
+	go s.settle(address, msg, receipt, attempt)
+	return nil
+}
+
+// settle waits for disposition of sent message and resends it if broker
+// released it or it was not settled in time
+func (s *amqpSink) settle(address string, msg *amqp.Message, receipt amqp.SendReceipt, attempt int) {
+	defer s.wg.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg.AckTimeout)*time.Second)
+	state, err := receipt.Wait(ctx)
+	cancel()
+	<-s.inflight
+	switch st := state.(type) {
+	case *amqp.StateAccepted:
+		return
+	case *amqp.StateRejected:
+		deliveryErrors.WithLabelValues(s.name).Inc()
+		slog.Error("record rejected by AMQP broker", "sink", s.name, "address", address, "error", st.Error)
+		return
+	case *amqp.StateReleased:
+		err = errors.New("record released by AMQP broker")
+	case *amqp.StateModified:
+		err = errors.New("record modified by AMQP broker")
+	case nil:
+		s.reset(err)
+	default:
+		err = fmt.Errorf("unexpected delivery state %T", st)
+	}
+	if attempt < s.cfg.Retries {
+		slog.Warn("retrying unsettled record", "sink", s.name, "address", address, "attempt", attempt+1, "error", err)
+		if s.send(address, msg, attempt+1) == nil {
+			return
+		}
+	}
+	deliveryErrors.WithLabelValues(s.name).Inc()
+	slog.Error("unable to deliver record", "sink", s.name, "address", address, "attempts", attempt+1, "error", err)
+}
+
+// Close implements Sink interface, it waits for disposition of unsettled records
+func (s *amqpSink) Close() error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Duration(s.cfg.AckTimeout) * time.Second):
+		slog.Warn("closing sink with unsettled records", "sink", s.name, "records", len(s.inflight))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.session, s.senders = nil, nil, nil
+	return err
+}
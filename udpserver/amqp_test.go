@@ -0,0 +1,47 @@
+package udpserver
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// TestAMQPSinkDisconnected checks that records fail immediately while
+// broker is unavailable and closed sink stops reconnecting
+func TestAMQPSinkDisconnected(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := newAMQPSink(config.Sink{Name: "amqp-disconnected", Type: "amqp", AMQP: config.AMQPSink{
+		URL:           "amqp://" + addr,
+		Address:       "cms.udp",
+		Settlement:    "unsettled",
+		Mechanism:     "anonymous",
+		SendTimeout:   10,
+		AckTimeout:    1,
+		MaxInflight:   10,
+		ReconnectWait: 1,
+	}}, jsonEncoder{})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := s.Send(Record{"n": i}); !errors.Is(err, errAMQPDisconnected) {
+			t.Fatalf("got error %v, want %v", err, errAMQPDisconnected)
+		}
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("sending to unavailable broker took %v", d)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(Record{"n": 3}); !errors.Is(err, errAMQPClosed) {
+		t.Errorf("got error %v, want %v", err, errAMQPClosed)
+	}
+}
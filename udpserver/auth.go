@@ -0,0 +1,207 @@
+package udpserver
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// signedPrefix starts header line of signed datagram
+var signedPrefix = []byte("HMAC ")
+
+// SignPacket returns payload with authentication header line
+//
+//	HMAC <key ID> <timestamp> <hex encoded signature>
+//
+// where signature is HMAC-SHA256 of "<key ID> <timestamp>\n<payload>" and
+// timestamp is unix time in seconds or 0 if datagram has no timestamp
+func SignPacket(keyID string, key []byte, ts int64, payload []byte) []byte {
+	header := keyID + " " + strconv.FormatInt(ts, 10)
+	sig := signature(key, header, payload)
+	data := []byte(string(signedPrefix) + header + " " + hex.EncodeToString(sig) + "\n")
+	return append(data, payload...)
+}
+
+// signature returns HMAC-SHA256 signature of header and payload
+func signature(key []byte, header string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(header + "\n"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// maxSeenSignatures limits number of remembered signatures, the ones which
+// expire first are forgotten when the limit is reached
+const maxSeenSignatures = 100000
+
+// seenSignature is a signature of timestamped datagram and its expiration
+// time in unix seconds
+type seenSignature struct {
+	sig    string
+	expire int64
+}
+
+// seenQueue is a min-heap of remembered signatures ordered by expiration time
+type seenQueue []seenSignature
+
+func (q seenQueue) Len() int           { return len(q) }
+func (q seenQueue) Less(i, j int) bool { return q[i].expire < q[j].expire }
+func (q seenQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *seenQueue) Push(x any)        { *q = append(*q, x.(seenSignature)) }
+func (q *seenQueue) Pop() any {
+	old := *q
+	s := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return s
+}
+
+// authenticator verifies signed datagrams
+type authenticator struct {
+	mu      sync.Mutex
+	keys    map[string][]byte
+	seen    map[string]struct{} // signatures of timestamped datagrams
+	expires seenQueue           // seen signatures by expiration time
+}
+
+// auth verifies datagrams of all UDP listeners
+var auth = &authenticator{seen: make(map[string]struct{})}
+
+// update loads keyring of given configuration, the old keys are kept
+// if keyring can't be loaded
+func (a *authenticator) update(cfg *config.Configuration) {
+	var keys map[string][]byte
+	if cfg.Auth.KeyringFile != "" {
+		var err error
+		keys, err = config.LoadKeyring(cfg.Auth.KeyringFile)
+		if err != nil {
+			slog.Error("unable to load keyring", "file", cfg.Auth.KeyringFile, "error", err)
+			return
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = keys
+}
+
+// authentication results
+const (
+	authSigned   = "signed"        // signature is valid
+	authUnsigned = "unsigned"      // datagram is not signed
+	authInvalid  = "invalid"       // authentication header is malformed
+	authKey      = "unknown_key"   // key ID is not in the keyring
+	authBad      = "bad_signature" // signature does not match
+	authNoTime   = "no_timestamp"  // timestamp is required but missing
+	authStale    = "stale"         // timestamp is out of allowed skew
+	authReplay   = "replay"        // datagram was already received
+)
+
+// verify checks signature of given datagram and returns its payload
+// and authentication result, error is returned for not authentic datagrams
+func (a *authenticator) verify(cfg config.Auth, data []byte, now time.Time) ([]byte, string, error) {
+	if !bytes.HasPrefix(data, signedPrefix) {
+		return data, authUnsigned, nil
+	}
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, authInvalid, errors.New("authentication header is not terminated")
+	}
+	fields := bytes.Fields(data[len(signedPrefix):end])
+	payload := data[end+1:]
+	if len(fields) != 3 {
+		return nil, authInvalid, errors.New("authentication header must contain key ID, timestamp and signature")
+	}
+	keyID, tsField := string(fields[0]), string(fields[1])
+	ts, err := strconv.ParseInt(tsField, 10, 64)
+	if err != nil {
+		return nil, authInvalid, fmt.Errorf("invalid timestamp %q", tsField)
+	}
+	sig, err := hex.DecodeString(string(fields[2]))
+	if err != nil {
+		return nil, authInvalid, errors.New("signature is not hex encoded")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key, ok := a.keys[keyID]
+	if !ok {
+		return nil, authKey, fmt.Errorf("unknown key ID %q", keyID)
+	}
+	if !hmac.Equal(sig, signature(key, keyID+" "+tsField, payload)) {
+		return nil, authBad, fmt.Errorf("signature mismatch for key ID %q", keyID)
+	}
+	if ts == 0 {
+		if cfg.RequireTimestamp {
+			return nil, authNoTime, errors.New("timestamp is required")
+		}
+		return payload, authSigned, nil
+	}
+	skew := time.Duration(cfg.MaxSkew) * time.Second
+	if t := time.Unix(ts, 0); t.Before(now.Add(-skew)) || t.After(now.Add(skew)) {
+		return nil, authStale, fmt.Errorf("timestamp %d is out of %v skew", ts, skew)
+	}
+
+	// timestamped datagrams are remembered until they become stale
+	a.expire(now)
+	seenKey := string(sig)
+	if _, ok := a.seen[seenKey]; ok {
+		return nil, authReplay, errors.New("datagram was already received")
+	}
+	a.seen[seenKey] = struct{}{}
+	heap.Push(&a.expires, seenSignature{sig: seenKey, expire: ts + int64(cfg.MaxSkew)})
+	return payload, authSigned, nil
+}
+
+// expire forgets stale signatures and the ones expiring first if there are
+// too many of them, must be called with lock held
+func (a *authenticator) expire(now time.Time) {
+	var evicted int
+	for len(a.expires) > 0 {
+		if a.expires[0].expire >= now.Unix() {
+			if len(a.expires) < maxSeenSignatures {
+				break
+			}
+			evicted++
+		}
+		s := heap.Pop(&a.expires).(seenSignature)
+		delete(a.seen, s.sig)
+	}
+	if evicted > 0 {
+		slog.Warn("too many signed datagrams, forgetting signatures before they expire", "limit", maxSeenSignatures)
+	}
+}
+
+// authenticate verifies datagram received by given listener according to
+// authentication configuration, it returns payload of authentic datagram
+// and fields to add to its records, or false if datagram is rejected
+func authenticate(cfg *config.Configuration, listener, remote string, data []byte) ([]byte, Record, bool) {
+	if cfg.Auth.KeyringFile == "" {
+		return data, nil, true
+	}
+	payload, res, err := auth.verify(cfg.Auth, data, time.Now())
+	authPackets.WithLabelValues(listener, res).Inc()
+	if err != nil {
+		reject(cfg, listener, remote, reasonAuth, data, err)
+		return nil, nil, false
+	}
+	signed := res == authSigned
+	switch cfg.Auth.Unsigned {
+	case "reject":
+		if !signed {
+			reject(cfg, listener, remote, reasonUnsigned, data, errors.New("datagram is not signed"))
+			return nil, nil, false
+		}
+	case "tag":
+		return payload, Record{cfg.Auth.TagField: signed}, true
+	}
+	return payload, nil, true
+}
@@ -0,0 +1,126 @@
+package udpserver
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// testKey is a key of test keyring
+var testKey = []byte("0123456789abcdef")
+
+// newTestAuthenticator returns authenticator with test keyring
+func newTestAuthenticator() *authenticator {
+	return &authenticator{keys: map[string][]byte{"k1": testKey}, seen: make(map[string]struct{})}
+}
+
+// TestVerify checks results of signed, unsigned and malformed datagrams
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	payload := []byte(`{"a": 1}`)
+	ts := now.Unix()
+	tampered := SignPacket("k1", testKey, ts, payload)
+	tampered[len(tampered)-2] = '2'
+	tests := []struct {
+		name   string
+		cfg    config.Auth
+		data   []byte
+		result string
+	}{
+		{"unsigned", config.Auth{}, payload, authUnsigned},
+		{"signed", config.Auth{}, SignPacket("k1", testKey, ts, payload), authSigned},
+		{"without timestamp", config.Auth{}, SignPacket("k1", testKey, 0, payload), authSigned},
+		{"timestamp required", config.Auth{RequireTimestamp: true}, SignPacket("k1", testKey, 0, payload), authNoTime},
+		{"unknown key", config.Auth{}, SignPacket("k2", testKey, ts, payload), authKey},
+		{"wrong key", config.Auth{}, SignPacket("k1", []byte("other"), ts, payload), authBad},
+		{"tampered payload", config.Auth{}, tampered, authBad},
+		{"not terminated", config.Auth{}, []byte("HMAC k1 0 00"), authInvalid},
+		{"missing signature", config.Auth{}, []byte("HMAC k1 0\n{}"), authInvalid},
+		{"invalid timestamp", config.Auth{}, []byte("HMAC k1 now 00\n{}"), authInvalid},
+		{"invalid signature", config.Auth{}, []byte("HMAC k1 0 xyz\n{}"), authInvalid},
+		{"oldest in skew", config.Auth{}, SignPacket("k1", testKey, ts-300, payload), authSigned},
+		{"newest in skew", config.Auth{}, SignPacket("k1", testKey, ts+300, payload), authSigned},
+		{"too old", config.Auth{}, SignPacket("k1", testKey, ts-301, payload), authStale},
+		{"too new", config.Auth{}, SignPacket("k1", testKey, ts+301, payload), authStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Configuration{Auth: tt.cfg}
+			cfg.SetDefaults()
+			got, res, err := newTestAuthenticator().verify(cfg.Auth, tt.data, now)
+			if res != tt.result {
+				t.Fatalf("got result %s, want %s (error %v)", res, tt.result, err)
+			}
+			ok := res == authSigned || res == authUnsigned
+			if ok != (err == nil) {
+				t.Errorf("got error %v for result %s", err, res)
+			}
+			if ok && !bytes.Equal(got, payload) {
+				t.Errorf("got payload %q, want %q", got, payload)
+			}
+		})
+	}
+}
+
+// TestVerifyReplay checks that timestamped datagrams are accepted once and
+// their signatures are forgotten after they expire
+func TestVerifyReplay(t *testing.T) {
+	cfg := config.Auth{MaxSkew: 10}
+	a := newTestAuthenticator()
+	now := time.Unix(1700000000, 0)
+	first := SignPacket("k1", testKey, now.Unix(), []byte(`{"a": 1}`))
+	steps := []struct {
+		data   []byte
+		after  time.Duration
+		result string
+		seen   int
+	}{
+		{first, 0, authSigned, 1},
+		{first, 5 * time.Second, authReplay, 1},
+		{SignPacket("k1", testKey, 0, []byte(`{"a": 1}`)), 5 * time.Second, authSigned, 1}, // not remembered
+		{SignPacket("k1", testKey, now.Unix()+5, []byte(`{"a": 2}`)), 5 * time.Second, authSigned, 2},
+		{first, 10 * time.Second, authReplay, 2},
+		{first, 11 * time.Second, authStale, 2},
+		// the first signature is forgotten with the next timestamped datagram
+		{SignPacket("k1", testKey, now.Unix()+11, []byte(`{"a": 3}`)), 11 * time.Second, authSigned, 2},
+	}
+	for i, s := range steps {
+		_, res, _ := a.verify(cfg, s.data, now.Add(s.after))
+		if res != s.result {
+			t.Errorf("step %d: got result %s, want %s", i, res, s.result)
+		}
+		if len(a.seen) != s.seen || len(a.expires) != s.seen {
+			t.Errorf("step %d: got %d seen and %d queued signatures, want %d", i, len(a.seen), len(a.expires), s.seen)
+		}
+	}
+}
+
+// TestSeenSignaturesLimit checks that signatures expiring first are
+// forgotten when maxSeenSignatures is reached
+func TestSeenSignaturesLimit(t *testing.T) {
+	cfg := config.Auth{MaxSkew: 300}
+	a := newTestAuthenticator()
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < maxSeenSignatures; i++ {
+		s := seenSignature{sig: fmt.Sprint(i), expire: now.Unix() + 100 + int64(i%100)}
+		a.seen[s.sig] = struct{}{}
+		heap.Push(&a.expires, s)
+	}
+	// the earliest expiring signature is the first one
+	if _, res, _ := a.verify(cfg, SignPacket("k1", testKey, now.Unix(), []byte("{}")), now); res != authSigned {
+		t.Fatalf("got result %s, want %s", res, authSigned)
+	}
+	if len(a.seen) != maxSeenSignatures || len(a.expires) != maxSeenSignatures {
+		t.Errorf("got %d seen and %d queued signatures, want %d", len(a.seen), len(a.expires), maxSeenSignatures)
+	}
+	if _, ok := a.seen["0"]; ok {
+		t.Error("the earliest expiring signature is kept")
+	}
+	if _, ok := a.seen["1"]; !ok {
+		t.Error("signature which does not expire first is forgotten")
+	}
+}
@@ -0,0 +1,100 @@
+package udpserver
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maxPendingBatches is a number of full batches waiting to be flushed,
+// the records are dropped when sink can't keep up
+const maxPendingBatches = 10
+
+// batcher collects items of a sink and flushes them in batches when batch
+// is full or flush interval passes, batches are flushed one at a time
+type batcher[T any] struct {
+	sink    string          // sink name used in logs and metrics
+	size    int             // maximum batch size
+	flush   func(batch []T) // function delivering the batch
+	mu      sync.Mutex
+	items   []T
+	full    chan []T
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newBatcher returns batcher flushing batches with given function
+func newBatcher[T any](sink string, size int, interval time.Duration, flush func([]T)) *batcher[T] {
+	b := &batcher[T]{
+		sink:    sink,
+		size:    size,
+		flush:   flush,
+		full:    make(chan []T, maxPendingBatches),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go b.run(interval)
+	return b
+}
+
+// add adds item to the current batch
+func (b *batcher[T]) add(item T) {
+	b.mu.Lock()
+	b.items = append(b.items, item)
+	if len(b.items) < b.size {
+		b.mu.Unlock()
+		return
+	}
+	batch := b.take()
+	b.mu.Unlock()
+	select {
+	case b.full <- batch:
+	default:
+		deliveryErrors.WithLabelValues(b.sink).Add(float64(len(batch)))
+		slog.Error("too many pending batches, dropping records", "sink", b.sink, "records", len(batch))
+	}
+}
+
+// take returns current batch and starts a new one, must be called with lock held
+func (b *batcher[T]) take() []T {
+	batch := b.items
+	b.items = nil
+	return batch
+}
+
+// run flushes full batches and partial ones every interval
+func (b *batcher[T]) run(interval time.Duration) {
+	defer close(b.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch := <-b.full:
+			b.flush(batch)
+		case <-ticker.C:
+			b.mu.Lock()
+			batch := b.take()
+			b.mu.Unlock()
+			if len(batch) > 0 {
+				b.flush(batch)
+			}
+		case <-b.done:
+			for len(b.full) > 0 {
+				b.flush(<-b.full)
+			}
+			b.mu.Lock()
+			batch := b.take()
+			b.mu.Unlock()
+			if len(batch) > 0 {
+				b.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// close flushes pending batches and stops the batcher
+func (b *batcher[T]) close() {
+	close(b.done)
+	<-b.stopped
+}
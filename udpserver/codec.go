@@ -0,0 +1,276 @@
+package udpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Record represents single record received by the server
+type Record = map[string]interface{}
+
+// DecodeError describes record which can't be decoded
+type DecodeError struct {
+	Reason string // reason of rejection
+	Data   []byte // raw record data
+	Err    error  // decoding error
+}
+
+// Error implements error interface
+func (e *DecodeError) Error() string {
+	return e.Err.Error()
+}
+
+// errNotObject is an error of record which is null instead of an object
+var errNotObject = errors.New("record is not an object")
+
+// Decoder decodes payload of a packet received from given source into
+// records, records which can't be decoded are reported as errors without
+// affecting the other ones
+type Decoder interface {
+	Decode(source string, data []byte) ([]Record, []*DecodeError)
+}
+
+// Encoder encodes records for delivery
+type Encoder interface {
+	ContentType() string
+	Encode(rec Record) ([]byte, error)
+}
+
+// NewDecoder returns decoder for given encoding name
+func NewDecoder(name string) (Decoder, error) {
+	switch name {
+	case "", "json":
+		return jsonDecoder{}, nil
+	case "msgpack":
+		return msgpackDecoder{}, nil
+	case "cbor":
+		return newCborDecoder()
+	case "xrootd":
+		return NewXrootdDecoder(), nil
+	}
+	return nil, fmt.Errorf("unsupported decoder %s", name)
+}
+
+// NewEncoder returns encoder for given encoding name
+func NewEncoder(name string) (Encoder, error) {
+	switch name {
+	case "", "json":
+		return jsonEncoder{}, nil
+	case "msgpack":
+		return msgpackEncoder{}, nil
+	case "cbor":
+		return cborEncoder{}, nil
+	}
+	return nil, fmt.Errorf("unsupported encoder %s", name)
+}
+
+// jsonDecoder decodes JSON records, optionally newline delimited
+type jsonDecoder struct {
+	arrays bool // split top-level JSON arrays into records
+}
+
+// Decode implements Decoder interface
+func (d jsonDecoder) Decode(_ string, data []byte) ([]Record, []*DecodeError) {
+	var records []Record
+	var errs []*DecodeError
+	for _, rec := range splitRecords(data, d.arrays) {
+		var packet Record
+		err := json.Unmarshal(rec, &packet)
+		if err == nil && packet == nil {
+			errs = append(errs, &DecodeError{Reason: reasonMalformed, Data: rec, Err: errNotObject})
+			continue
+		}
+		if err == nil {
+			records = append(records, packet)
+			continue
+		}
+		reason := reasonMalformed
+		e := err.Error()
+		if strings.Contains(e, "invalid character") {
+			reason = reasonInvalidJSON
+		} else if strings.Contains(e, "unexpected end of JSON input") {
+			reason = reasonTruncated
+		}
+		errs = append(errs, &DecodeError{Reason: reason, Data: rec, Err: err})
+	}
+	return records, errs
+}
+
+// splitRecords splits datagram into newline delimited records, the datagram
+// which is valid JSON on its own, e.g. pretty-printed one, is a single record
+// and JSON array is split into its elements if arrays is set. Truncated
+// pretty-printed record, whose first line is not a complete record, is kept
+// whole to be reported as truncated.
+func splitRecords(data []byte, arrays bool) [][]byte {
+	data = bytes.TrimSpace(data)
+	if arrays && bytes.HasPrefix(data, []byte("[")) {
+		var elements []json.RawMessage
+		if err := json.Unmarshal(data, &elements); err == nil {
+			records := make([][]byte, len(elements))
+			for i, e := range elements {
+				records[i] = e
+			}
+			return records
+		}
+	}
+	if !bytes.Contains(data, []byte("\n")) || json.Valid(data) {
+		return [][]byte{data}
+	}
+	first, _, _ := bytes.Cut(data, []byte("\n"))
+	if !json.Valid(first) && truncatedJSON(data) {
+		return [][]byte{data}
+	}
+	var records [][]byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			records = append(records, line)
+		}
+	}
+	return records
+}
+
+// truncatedJSON checks whether data is a beginning of valid JSON value
+func truncatedJSON(data []byte) bool {
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	return err != nil && strings.Contains(err.Error(), "unexpected end of JSON input")
+}
+
+// msgpackDecoder decodes one or more concatenated MessagePack maps
+type msgpackDecoder struct{}
+
+// Decode implements Decoder interface
+func (msgpackDecoder) Decode(_ string, data []byte) ([]Record, []*DecodeError) {
+	r := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(r)
+	var records []Record
+	var errs []*DecodeError
+	for r.Len() > 0 {
+		offset := len(data) - r.Len()
+		rec, err := dec.DecodeMap()
+		if err != nil {
+			return records, append(errs, binaryError(data[offset:], err))
+		}
+		if rec == nil {
+			errs = append(errs, &DecodeError{Reason: reasonMalformed, Data: data[offset : len(data)-r.Len()], Err: errNotObject})
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, errs
+}
+
+// cborDecoder decodes one or more concatenated CBOR maps
+type cborDecoder struct {
+	mode cbor.DecMode
+}
+
+// newCborDecoder returns CBOR decoder which decodes maps with string keys
+func newCborDecoder() (Decoder, error) {
+	mode, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(Record(nil)),
+	}.DecMode()
+	if err != nil {
+		return nil, err
+	}
+	return cborDecoder{mode: mode}, nil
+}
+
+// Decode implements Decoder interface
+func (d cborDecoder) Decode(_ string, data []byte) ([]Record, []*DecodeError) {
+	var records []Record
+	var errs []*DecodeError
+	for len(data) > 0 {
+		var rec Record
+		rest, err := d.mode.UnmarshalFirst(data, &rec)
+		if err != nil {
+			return records, append(errs, binaryError(data, err))
+		}
+		if rec == nil {
+			errs = append(errs, &DecodeError{Reason: reasonMalformed, Data: data[:len(data)-len(rest)], Err: errNotObject})
+		} else {
+			records = append(records, rec)
+		}
+		data = rest
+	}
+	return records, errs
+}
+
+// binaryError returns decode error of binary encoded record, the rest of
+// the packet can't be decoded after such error
+func binaryError(data []byte, err error) *DecodeError {
+	reason := reasonMalformed
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		reason = reasonTruncated
+	}
+	return &DecodeError{Reason: reason, Data: data, Err: err}
+}
+
+// jsonEncoder encodes records as JSON
+type jsonEncoder struct{}
+
+// ContentType implements Encoder interface
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+// Encode implements Encoder interface
+func (jsonEncoder) Encode(rec Record) ([]byte, error) { return json.Marshal(rec) }
+
+// msgpackEncoder encodes records as MessagePack
+type msgpackEncoder struct{}
+
+// ContentType implements Encoder interface
+func (msgpackEncoder) ContentType() string { return "application/msgpack" }
+
+// Encode implements Encoder interface
+func (msgpackEncoder) Encode(rec Record) ([]byte, error) { return msgpack.Marshal(rec) }
+
+// cborEncoder encodes records as CBOR
+type cborEncoder struct{}
+
+// ContentType implements Encoder interface
+func (cborEncoder) ContentType() string { return "application/cbor" }
+
+// Encode implements Encoder interface
+func (cborEncoder) Encode(rec Record) ([]byte, error) { return cbor.Marshal(rec) }
+
+// unixTime converts unix time in seconds of any numeric type produced by
+// the decoders to time
+func unixTime(v interface{}) (time.Time, bool) {
+	switch ts := v.(type) {
+	case float64:
+		return time.Unix(int64(ts), 0), true
+	case float32:
+		return time.Unix(int64(ts), 0), true
+	case int:
+		return time.Unix(int64(ts), 0), true
+	case int8:
+		return time.Unix(int64(ts), 0), true
+	case int16:
+		return time.Unix(int64(ts), 0), true
+	case int32:
+		return time.Unix(int64(ts), 0), true
+	case int64:
+		return time.Unix(ts, 0), true
+	case uint:
+		return time.Unix(int64(ts), 0), true
+	case uint8:
+		return time.Unix(int64(ts), 0), true
+	case uint16:
+		return time.Unix(int64(ts), 0), true
+	case uint32:
+		return time.Unix(int64(ts), 0), true
+	case uint64:
+		return time.Unix(int64(ts), 0), true
+	}
+	return time.Time{}, false
+}
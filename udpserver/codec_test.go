@@ -0,0 +1,77 @@
+package udpserver
+
+import (
+	"testing"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// TestDecodeNullRecord checks that null records are rejected as malformed
+// while the other records of the packet are decoded
+func TestDecodeNullRecord(t *testing.T) {
+	record := map[string]int{"a": 1}
+	msgpackNull, _ := msgpack.Marshal(nil)
+	msgpackRecord, _ := msgpack.Marshal(record)
+	cborNull, _ := cbor.Marshal(nil)
+	cborRecord, _ := cbor.Marshal(record)
+	cborDec, err := newCborDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		decoder Decoder
+		data    []byte
+		records int
+	}{
+		{"json", jsonDecoder{}, []byte("null"), 0},
+		{"json array", jsonDecoder{arrays: true}, []byte(`[null, {"a": 1}]`), 1},
+		{"json lines", jsonDecoder{}, []byte("{\"a\": 1}\nnull"), 1},
+		{"msgpack", msgpackDecoder{}, append(msgpackNull, msgpackRecord...), 1},
+		{"cbor", cborDec, append(cborNull, cborRecord...), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, errs := tt.decoder.Decode("test", tt.data)
+			if len(records) != tt.records {
+				t.Errorf("got %d records, want %d", len(records), tt.records)
+			}
+			for _, rec := range records {
+				if rec == nil {
+					t.Error("got nil record")
+				}
+			}
+			if len(errs) != 1 || errs[0].Reason != reasonMalformed {
+				t.Fatalf("got errors %v, want one %s error", errs, reasonMalformed)
+			}
+		})
+	}
+}
+
+// TestJSONArrays checks that JSON arrays are split into records by HTTP
+// listeners only, while UDP and TCP listeners reject them as one record
+func TestJSONArrays(t *testing.T) {
+	cfg := &config.Configuration{RejectedPayloadSize: 100}
+	data := []byte(`[{"a": 1}, {"a": 2}]`)
+	tests := []struct {
+		protocol string
+		accepted int
+		rejected int
+	}{
+		{"udp", 0, 1},
+		{"tcp", 0, 1},
+		{"http", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			p := newPipeline(config.Listener{Name: "arrays-" + tt.protocol, Protocol: tt.protocol, Decoder: "json"})
+			res := p.handle(cfg, packet{remote: "192.0.2.1:1234", data: data})
+			if res.accepted != tt.accepted || res.rejected != tt.rejected {
+				t.Errorf("got %+v, want %d accepted and %d rejected", res, tt.accepted, tt.rejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package udpserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// supported compression types
+const (
+	compressionGzip   = "gzip"
+	compressionZstd   = "zstd"
+	compressionSnappy = "snappy"
+)
+
+// magic bytes of compressed payloads
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY") // snappy framing format stream identifier
+)
+
+// errTooLarge is returned when decompressed payload exceeds allowed size
+var errTooLarge = errors.New("decompressed payload is too large")
+
+// detectCompression returns compression type of given data and compressed
+// payload, the type is empty for uncompressed data
+func detectCompression(cfg *config.Configuration, data []byte) (string, []byte) {
+	for ctype, prefix := range cfg.CompressionPrefixes {
+		if prefix != "" && bytes.HasPrefix(data, []byte(prefix)) {
+			return ctype, data[len(prefix):]
+		}
+	}
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return compressionGzip, data
+	case bytes.HasPrefix(data, zstdMagic):
+		return compressionZstd, data
+	case bytes.HasPrefix(data, snappyMagic):
+		return compressionSnappy, data
+	}
+	return "", data
+}
+
+// decompress detects compression of data received by given listener and
+// decompresses it, uncompressed data is returned as is
+func decompress(cfg *config.Configuration, listener string, data []byte) ([]byte, error) {
+	ctype, payload := detectCompression(cfg, data)
+	if ctype == "" {
+		return data, nil
+	}
+	compressedPackets.WithLabelValues(listener, ctype).Inc()
+	maxSize := cfg.MaxDecompressedSize
+	switch ctype {
+	case compressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r, maxSize)
+	case compressionZstd:
+		r, err := zstd.NewReader(bytes.NewReader(payload), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r, maxSize)
+	case compressionSnappy:
+		if bytes.HasPrefix(payload, snappyMagic) {
+			data, err := readLimited(snappy.NewReader(bytes.NewReader(payload)), maxSize)
+			if err != nil && snappyTruncated(payload) {
+				return nil, fmt.Errorf("truncated snappy stream: %w", io.ErrUnexpectedEOF)
+			}
+			return data, err
+		}
+		// snappy block format
+		size, err := snappy.DecodedLen(payload)
+		if err != nil {
+			return nil, err
+		}
+		if size > maxSize {
+			return nil, errTooLarge
+		}
+		return snappy.Decode(nil, payload)
+	}
+	return nil, fmt.Errorf("unsupported compression %s", ctype)
+}
+
+// snappyTruncated checks if the last chunk of snappy framing format stream
+// is shorter than its header declares
+func snappyTruncated(data []byte) bool {
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return true
+		}
+		size := int(data[1]) | int(data[2])<<8 | int(data[3])<<16
+		if len(data) < 4+size {
+			return true
+		}
+		data = data[4+size:]
+	}
+	return false
+}
+
+// readLimited reads all data from given reader up to given size
+func readLimited(r io.Reader, maxSize int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,96 @@
+package udpserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressed returns given data compressed by given compression type
+func compressed(t *testing.T, ctype string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch ctype {
+	case compressionGzip:
+		w = gzip.NewWriter(&buf)
+	case compressionZstd:
+		var err error
+		if w, err = zstd.NewWriter(&buf); err != nil {
+			t.Fatal(err)
+		}
+	case compressionSnappy:
+		w = snappy.NewBufferedWriter(&buf)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestDecompress checks that only truncated payloads are reported as
+// unexpected end of data, while corrupt and too large ones are not
+func TestDecompress(t *testing.T) {
+	cfg := &config.Configuration{MaxDecompressedSize: 1000}
+	record := []byte(`{"site_name": "T2_CH_CERN", "file_lfn": "/store/data/file.root"}`)
+	bomb := bytes.Repeat([]byte(" "), 100000)
+	for _, ctype := range []string{compressionGzip, compressionZstd, compressionSnappy} {
+		t.Run(ctype, func(t *testing.T) {
+			data := compressed(t, ctype, record)
+			if payload, err := decompress(cfg, "test", data); err != nil || !bytes.Equal(payload, record) {
+				t.Errorf("got payload %q and error %v", payload, err)
+			}
+			if _, err := decompress(cfg, "test", data[:len(data)-4]); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("truncated payload: got error %v, want %v", err, io.ErrUnexpectedEOF)
+			}
+			corrupt := bytes.Clone(data)
+			corrupt[len(corrupt)/2] ^= 0xff
+			corrupt[len(corrupt)/2+1] ^= 0xff
+			if _, err := decompress(cfg, "test", corrupt); err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("corrupt payload: got error %v", err)
+			}
+			if _, err := decompress(cfg, "test", compressed(t, ctype, bomb)); !errors.Is(err, errTooLarge) {
+				t.Errorf("large payload: got error %v, want %v", err, errTooLarge)
+			}
+		})
+	}
+}
+
+// TestHandleCompressed checks that only truncated compressed packets are
+// reported as incomplete, so that the receive buffer is not grown for
+// decompression bombs and corrupt payloads
+func TestHandleCompressed(t *testing.T) {
+	cfg := &config.Configuration{MaxDecompressedSize: 1000, RejectedPayloadSize: 100}
+	data := compressed(t, compressionGzip, []byte(`{"a": 1}`))
+	corrupt := bytes.Clone(data)
+	corrupt[len(corrupt)-8] ^= 0xff
+	tests := []struct {
+		name       string
+		data       []byte
+		accepted   int
+		incomplete bool
+	}{
+		{"valid", data, 1, false},
+		{"truncated", data[:len(data)-4], 0, true},
+		{"corrupt", corrupt, 0, false},
+		{"too large", compressed(t, compressionGzip, bytes.Repeat([]byte(" "), 100000)), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPipeline(config.Listener{Name: "compressed", Decoder: "json"})
+			res := p.handle(cfg, packet{remote: "192.0.2.1:1234", data: tt.data})
+			if res.accepted != tt.accepted || res.incomplete != tt.incomplete {
+				t.Errorf("got %+v, want %d accepted and incomplete %v", res, tt.accepted, tt.incomplete)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package udpserver
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/klauspost/compress/gzip"
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+)
+
+// fileSink writes records as newline-delimited JSON into rotating files
+type fileSink struct {
+	name    string
+	encoder Encoder
+	mu      sync.Mutex
+	writer  *rotatelogs.RotateLogs
+}
+
+// newFileSink returns file sink writing into files of given pattern
+func newFileSink(cfg config.Sink, encoder Encoder) (*fileSink, error) {
+	f := cfg.File
+	opts := []rotatelogs.Option{
+		rotatelogs.WithRotationTime(time.Duration(f.RotationTime) * time.Second),
+	}
+	if f.RotationSize > 0 {
+		opts = append(opts, rotatelogs.WithRotationSize(int64(f.RotationSize)))
+	}
+	if f.MaxFiles > 0 {
+		opts = append(opts, rotatelogs.WithRotationCount(uint(f.MaxFiles)))
+	} else {
+		opts = append(opts, rotatelogs.WithMaxAge(time.Duration(f.MaxAge)*time.Second))
+	}
+	if f.Compress {
+		opts = append(opts, rotatelogs.WithHandler(rotatelogs.HandlerFunc(func(e rotatelogs.Event) {
+			if e, ok := e.(*rotatelogs.FileRotatedEvent); ok && e.PreviousFile() != "" {
+				compressFile(cfg.Name, e.PreviousFile())
+			}
+		})))
+	}
+	writer, err := rotatelogs.New(f.Path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{name: cfg.Name, encoder: encoder, writer: writer}, nil
+}
+
+// Send implements Sink interface
+func (s *fileSink) Send(rec Record) error {
+	data, err := s.encoder.Encode(rec)
+	if err != nil {
+		return &encodeError{err}
+	}
+	data = append(data, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.writer.Write(data); err != nil {
+		slog.Error("unable to write records", "sink", s.name, "file", s.writer.CurrentFileName(), "error", err)
+		return err
+	}
+	return nil
+}
+
+// Close implements Sink interface
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writer.Close()
+}
+
+// compressFile replaces closed file with its gzipped copy
+func compressFile(sink, file string) {
+	if err := gzipFile(file); err != nil {
+		slog.Error("unable to compress file", "sink", sink, "file", file, "error", err)
+		return
+	}
+	os.Remove(file)
+	slog.Debug("compressed file", "sink", sink, "file", file+".gz")
+}
+
+// gzipFile writes gzipped copy of given file
+func gzipFile(file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(file + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(file + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(file + ".gz")
+		return err
+	}
+	return out.Close()
+}
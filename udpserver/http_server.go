@@ -0,0 +1,103 @@
+package udpserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// httpIngest accepts records sent in HTTP POST requests
+type httpIngest struct {
+	last config.Listener // initial listener configuration
+}
+
+// httpServer serves HTTP ingest requests of given listener
+func httpServer(ln net.Listener, l config.Listener) {
+	server := &http.Server{
+		Handler:           &httpIngest{last: l},
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       time.Duration(l.IdleTimeout) * time.Second,
+	}
+	if err := server.Serve(ln); err != nil {
+		slog.Error("HTTP server failed", "listener", l.Name, "error", err)
+	}
+}
+
+// ServeHTTP implements http.Handler interface, it answers with numbers of
+// accepted and rejected records
+func (h *httpIngest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	cfg := store.Get()
+	p := newPipeline(h.last)
+	l := p.listener(cfg)
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	remote := r.RemoteAddr
+	if err == nil {
+		remote = canonicalAddr(addr)
+	}
+	// ping request of monitoring server on local host does not require
+	// authorization, other sources are subject to all checks
+	if r.URL.Path == "/ping" && err == nil && addr.Addr().Unmap().IsLoopback() {
+		pong(cfg, l.Name, remote)
+		h.reply(w, l.Name, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	if err == nil && !permitted(cfg, l.Name, remote, addr.Addr(), nil) {
+		h.reply(w, l.Name, http.StatusForbidden, map[string]string{"error": "source address is not allowed"})
+		return
+	}
+	if r.Method != "POST" {
+		h.reply(w, l.Name, http.StatusMethodNotAllowed, map[string]string{"error": "only POST requests are accepted"})
+		return
+	}
+	if !authorized(l, r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="udp_collector"`)
+		h.reply(w, l.Name, http.StatusUnauthorized, map[string]string{"error": "invalid or missing bearer token"})
+		return
+	}
+	if err == nil && rateLimited(l.Name, addr.Addr()) {
+		h.reply(w, l.Name, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(l.MaxBodySize)))
+	if err != nil {
+		var merr *http.MaxBytesError
+		if errors.As(err, &merr) {
+			reject(cfg, l.Name, remote, reasonTruncated, body, err)
+			h.reply(w, l.Name, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+			return
+		}
+		h.reply(w, l.Name, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	res := p.handle(cfg, packet{remote: remote, source: addr, received: received, data: body})
+	h.reply(w, l.Name, http.StatusAccepted, map[string]int{"accepted": res.accepted, "rejected": res.rejected})
+}
+
+// reply writes JSON response with given status code
+func (h *httpIngest) reply(w http.ResponseWriter, listener string, code int, body interface{}) {
+	httpRequests.WithLabelValues(listener, strconv.Itoa(code)).Inc()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// authorized checks bearer token of the request if listener requires it
+func authorized(l *config.Listener, r *http.Request) bool {
+	if l.Token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(l.Token)) == 1
+}
@@ -0,0 +1,118 @@
+package udpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// kafkaSink produces records to Kafka topic, records are batched and
+// delivered asynchronously, delivery errors are counted when batches fail
+type kafkaSink struct {
+	name     string
+	keyField string
+	encoder  Encoder
+	writer   *kafka.Writer
+}
+
+// kafkaCompressions maps configured compression to Kafka codec
+var kafkaCompressions = map[string]kafka.Compression{
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
+}
+
+// kafkaAcks maps configured acknowledgements to Kafka ones
+var kafkaAcks = map[string]kafka.RequiredAcks{
+	"none": kafka.RequireNone,
+	"one":  kafka.RequireOne,
+	"all":  kafka.RequireAll,
+}
+
+// newKafkaSink returns Kafka sink producing to configured topic
+func newKafkaSink(cfg config.Sink, encoder Encoder) (*kafkaSink, error) {
+	k := cfg.Kafka
+	transport := &kafka.Transport{DialTimeout: time.Duration(k.WriteTimeout) * time.Second}
+	if k.TLS != nil {
+		tlsConfig, err := clientTLSConfig(k.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLS = tlsConfig
+	}
+	if k.SASL != nil {
+		mechanism, err := saslMechanism(k.SASL)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+	s := &kafkaSink{name: cfg.Name, keyField: k.KeyField, encoder: encoder}
+	s.writer = &kafka.Writer{
+		Addr:         kafka.TCP(k.Brokers...),
+		Topic:        k.Topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    k.BatchSize,
+		BatchTimeout: time.Duration(k.BatchTimeout) * time.Millisecond,
+		WriteTimeout: time.Duration(k.WriteTimeout) * time.Second,
+		RequiredAcks: kafkaAcks[k.Acks],
+		Compression:  kafkaCompressions[k.Compression],
+		Transport:    transport,
+		Async:        true,
+		Completion:   s.completed,
+	}
+	return s, nil
+}
+
+// saslMechanism returns SASL mechanism of given configuration
+func saslMechanism(cfg *config.SASLConfig) (sasl.Mechanism, error) {
+	switch cfg.Mechanism {
+	case "plain":
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	}
+	return nil, fmt.Errorf("unsupported SASL mechanism %q", cfg.Mechanism)
+}
+
+// Send implements Sink interface
+func (s *kafkaSink) Send(rec Record) error {
+	data, err := s.encoder.Encode(rec)
+	if err != nil {
+		return &encodeError{err}
+	}
+	msg := kafka.Message{Value: data}
+	if val, ok := rec[s.keyField]; ok && s.keyField != "" {
+		msg.Key = []byte(fmt.Sprint(val))
+	}
+	// the writer is asynchronous, delivery errors are reported to completed
+	if err := s.writer.WriteMessages(context.Background(), msg); err != nil {
+		slog.Error("unable to send data", "sink", s.name, "topic", s.writer.Topic, "bytes", len(data), "error", err)
+		return err
+	}
+	return nil
+}
+
+// completed counts delivery errors of batches sent to Kafka
+func (s *kafkaSink) completed(messages []kafka.Message, err error) {
+	if err == nil {
+		return
+	}
+	deliveryErrors.WithLabelValues(s.name).Add(float64(len(messages)))
+	slog.Error("unable to deliver records", "sink", s.name, "topic", s.writer.Topic, "records", len(messages), "error", err)
+}
+
+// Close implements Sink interface, it flushes pending batches
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,186 @@
+package udpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+)
+
+// kafkaMessage is a message received by fake Kafka broker
+type kafkaMessage struct {
+	topic     string
+	partition int32
+	key       []byte
+	value     []byte
+}
+
+// fakeKafka is an in-process Kafka broker answering metadata and produce
+// requests of kafka.Writer
+type fakeKafka struct {
+	partitions int         // number of partitions of every topic
+	errorCode  kafka.Error // error returned for produce requests, if set
+	mu         sync.Mutex
+	messages   []kafkaMessage
+}
+
+// RoundTrip implements kafka.RoundTripper interface
+func (b *fakeKafka) RoundTrip(_ context.Context, _ net.Addr, req kafka.Request) (kafka.Response, error) {
+	switch r := req.(type) {
+	case *metadata.Request:
+		res := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: "fake", Port: 9092}}}
+		for _, name := range r.TopicNames {
+			topic := metadata.ResponseTopic{Name: name}
+			for i := 0; i < b.partitions; i++ {
+				topic.Partitions = append(topic.Partitions, metadata.ResponsePartition{PartitionIndex: int32(i), LeaderID: 1})
+			}
+			res.Topics = append(res.Topics, topic)
+		}
+		return res, nil
+	case *produce.Request:
+		res := &produce.Response{}
+		for _, topic := range r.Topics {
+			rt := produce.ResponseTopic{Topic: topic.Topic}
+			for _, p := range topic.Partitions {
+				if err := b.store(topic.Topic, p); err != nil {
+					return nil, err
+				}
+				rt.Partitions = append(rt.Partitions, produce.ResponsePartition{Partition: p.Partition, ErrorCode: int16(b.errorCode)})
+			}
+			res.Topics = append(res.Topics, rt)
+		}
+		return res, nil
+	}
+	return nil, errors.New("unsupported request")
+}
+
+// store keeps produced messages of accepted partition record set
+func (b *fakeKafka) store(topic string, p produce.RequestPartition) error {
+	if b.errorCode != 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		rec, err := p.RecordSet.Records.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		msg := kafkaMessage{topic: topic, partition: p.Partition}
+		if rec.Key != nil {
+			msg.key, _ = protocol.ReadAll(rec.Key)
+		}
+		msg.value, _ = protocol.ReadAll(rec.Value)
+		b.messages = append(b.messages, msg)
+	}
+}
+
+// newTestKafkaSink returns Kafka sink producing to given fake broker
+func newTestKafkaSink(t *testing.T, name string, broker *fakeKafka, cfg config.KafkaSink) *kafkaSink {
+	t.Helper()
+	cfg.Brokers = []string{"fake:9092"}
+	cfg.BatchSize = 10
+	cfg.BatchTimeout = 10
+	cfg.WriteTimeout = 5
+	cfg.Acks = "all"
+	s, err := newKafkaSink(config.Sink{Name: name, Type: "kafka", Kafka: cfg}, jsonEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.writer.Transport = broker
+	return s
+}
+
+// TestKafkaSinkTopic checks that records are produced to configured topic
+func TestKafkaSinkTopic(t *testing.T) {
+	broker := &fakeKafka{partitions: 1}
+	s := newTestKafkaSink(t, "kafka-topic", broker, config.KafkaSink{Topic: "cms-udp"})
+	for _, site := range []string{"T2_CH_CERN", "T1_US_FNAL"} {
+		if err := s.Send(Record{"site_name": site}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(broker.messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(broker.messages))
+	}
+	for _, msg := range broker.messages {
+		if msg.topic != "cms-udp" {
+			t.Errorf("got topic %q, want cms-udp", msg.topic)
+		}
+		if msg.key != nil {
+			t.Errorf("got key %q without key field", msg.key)
+		}
+	}
+	if string(broker.messages[0].value) != `{"site_name":"T2_CH_CERN"}` {
+		t.Errorf("unexpected message value %s", broker.messages[0].value)
+	}
+}
+
+// TestKafkaSinkKey checks that key field is used as partition key, so
+// records with the same key are produced to the same partition
+func TestKafkaSinkKey(t *testing.T) {
+	broker := &fakeKafka{partitions: 8}
+	s := newTestKafkaSink(t, "kafka-key", broker, config.KafkaSink{Topic: "cms-udp", KeyField: "site_name"})
+	sites := []string{"T2_CH_CERN", "T1_US_FNAL", "T2_DE_DESY", "T2_CH_CERN", "T1_US_FNAL", "T2_DE_DESY"}
+	for _, site := range sites {
+		if err := s.Send(Record{"site_name": site}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Send(Record{"user": "cms"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(broker.messages) != len(sites)+1 {
+		t.Fatalf("got %d messages, want %d", len(broker.messages), len(sites)+1)
+	}
+	partitions := make(map[string]int32)
+	for _, msg := range broker.messages {
+		if msg.key == nil {
+			continue
+		}
+		key := string(msg.key)
+		if p, ok := partitions[key]; ok && p != msg.partition {
+			t.Errorf("key %s produced to partitions %d and %d", key, p, msg.partition)
+		}
+		partitions[key] = msg.partition
+	}
+	if len(partitions) != 3 {
+		t.Errorf("got keys %v, want 3 sites", partitions)
+	}
+}
+
+// TestKafkaSinkDeliveryErrors checks that records of failed batches are
+// counted as delivery errors
+func TestKafkaSinkDeliveryErrors(t *testing.T) {
+	broker := &fakeKafka{partitions: 1, errorCode: kafka.TopicAuthorizationFailed}
+	s := newTestKafkaSink(t, "kafka-errors", broker, config.KafkaSink{Topic: "cms-udp"})
+	before := testutil.ToFloat64(deliveryErrors.WithLabelValues("kafka-errors"))
+	for i := 0; i < 3; i++ {
+		if err := s.Send(Record{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.ToFloat64(deliveryErrors.WithLabelValues("kafka-errors")) - before; n != 3 {
+		t.Errorf("got %v delivery errors, want 3", n)
+	}
+}
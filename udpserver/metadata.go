@@ -0,0 +1,61 @@
+package udpserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync/atomic"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// hostname of the collector
+var hostname, _ = os.Hostname()
+
+// instanceID identifies collector process if it is not configured
+var instanceID = randomID()
+
+// sequence numbers records received by the collector
+var sequence atomic.Uint64
+
+// randomID returns random hex encoded identifier
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// addMetadata adds configured receive metadata of given packet to the record
+func addMetadata(cfg *config.Configuration, listener string, pkt packet, rec Record) {
+	fields := cfg.Metadata.Fields
+	if len(fields) == 0 {
+		return
+	}
+	meta := rec
+	if cfg.Metadata.Nest != "" {
+		meta = make(Record, len(fields))
+		rec[cfg.Metadata.Nest] = meta
+	}
+	for field, name := range fields {
+		switch field {
+		case "receiveTime":
+			meta[name] = pkt.received.UnixMilli()
+		case "sourceIP":
+			meta[name] = pkt.source.Addr().Unmap().String()
+		case "sourcePort":
+			meta[name] = pkt.source.Port()
+		case "hostname":
+			meta[name] = hostname
+		case "instanceID":
+			id := cfg.Metadata.InstanceID
+			if id == "" {
+				id = instanceID
+			}
+			meta[name] = id
+		case "listener":
+			meta[name] = listener
+		case "sequence":
+			meta[name] = sequence.Add(1)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package udpserver
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const metricPrefix = "udp_server_"
+
+// receivedPackets counts received packets per listener
+var receivedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "packets_total",
+	Help: "Number of received packets per listener",
+}, []string{"listener"})
+
+// receivedRecords counts decoded records per listener
+var receivedRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "records_total",
+	Help: "Number of decoded records per listener",
+}, []string{"listener"})
+
+// rejectedPackets counts rejected packets per listener and rejection reason
+var rejectedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "rejected_packets_total",
+	Help: "Number of rejected packets per listener and reason",
+}, []string{"listener", "reason"})
+
+// recordsPerDatagram observes number of records in received datagrams
+var recordsPerDatagram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    metricPrefix + "records_per_datagram",
+	Help:    "Number of records per received datagram",
+	Buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128},
+}, []string{"listener"})
+
+// compressedPackets counts compressed packets per listener and compression type
+var compressedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "compressed_packets_total",
+	Help: "Number of compressed packets per listener and compression type",
+}, []string{"listener", "type"})
+
+// sentRecords counts records delivered to sinks
+var sentRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "sent_records_total",
+	Help: "Number of records delivered per sink",
+}, []string{"sink"})
+
+// sinkErrors counts records which sinks failed to deliver
+var sinkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "sink_errors_total",
+	Help: "Number of records which failed to be delivered per sink",
+}, []string{"sink"})
+
+// deliveryErrorsTotal returns number of records given sinks failed to deliver
+func deliveryErrorsTotal(names []string) float64 {
+	var total float64
+	for _, name := range names {
+		var m dto.Metric
+		if err := deliveryErrors.WithLabelValues(name).Write(&m); err == nil {
+			total += m.GetCounter().GetValue()
+		}
+	}
+	return total
+}
+
+// deliveryErrors counts records which sinks accepted but failed to deliver
+// asynchronously, e.g. batches rejected by Kafka brokers
+var deliveryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "sink_delivery_errors_total",
+	Help: "Number of accepted records which failed to be delivered per sink",
+}, []string{"sink"})
+
+// tcpConnections shows number of open TCP connections per listener
+var tcpConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: metricPrefix + "tcp_connections",
+	Help: "Number of open TCP connections per listener",
+}, []string{"listener"})
+
+// tcpAcceptedConnections counts accepted TCP connections per listener
+var tcpAcceptedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "tcp_accepted_connections_total",
+	Help: "Number of accepted TCP connections per listener",
+}, []string{"listener"})
+
+// tcpRefusedConnections counts TCP connections closed because of the connection limit
+var tcpRefusedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "tcp_refused_connections_total",
+	Help: "Number of TCP connections refused due to connection limit per listener",
+}, []string{"listener"})
+
+// tcpConnectionLines observes number of lines received per TCP connection
+var tcpConnectionLines = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    metricPrefix + "tcp_connection_lines",
+	Help:    "Number of lines received per TCP connection",
+	Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+}, []string{"listener"})
+
+// tcpConnectionBytes observes number of bytes received per TCP connection
+var tcpConnectionBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    metricPrefix + "tcp_connection_bytes",
+	Help:    "Number of bytes received per TCP connection",
+	Buckets: prometheus.ExponentialBuckets(256, 4, 10),
+}, []string{"listener"})
+
+// tcpConnectionDuration observes duration of TCP connections
+var tcpConnectionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    metricPrefix + "tcp_connection_duration_seconds",
+	Help:    "Duration of TCP connections per listener",
+	Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+}, []string{"listener"})
+
+// httpRequests counts HTTP ingest requests per listener and status code
+var httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "http_requests_total",
+	Help: "Number of HTTP ingest requests per listener and status code",
+}, []string{"listener", "code"})
+
+// deniedPackets counts packets of not allowed sources per listener and
+// matching deny network or source network missing in allow list, the
+// number of the latter is bounded by maxDeniedNetworks
+var deniedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "denied_packets_total",
+	Help: "Number of packets from not allowed sources per listener and deny network or source network missing in allow list",
+}, []string{"listener", "network"})
+
+// rateLimitedPackets counts packets over rate limits per listener, exceeded limit and action
+var rateLimitedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "rate_limited_packets_total",
+	Help: "Number of packets over rate limits per listener, limit and action",
+}, []string{"listener", "limit", "action"})
+
+// topOffenders shows number of packets over rate limits of top offending sources
+var topOffenders = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: metricPrefix + "rate_limit_top_offenders",
+	Help: "Number of packets over rate limits of top offending sources",
+}, []string{"source"})
+
+// authPackets counts verified datagrams per listener and authentication result
+var authPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: metricPrefix + "auth_packets_total",
+	Help: "Number of verified datagrams per listener and authentication result",
+}, []string{"listener", "result"})
@@ -0,0 +1,155 @@
+package udpserver
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// attemptHeader counts publish attempts of JetStream messages
+const attemptHeader = "Udp-Collector-Attempt"
+
+// natsSink publishes records to NATS subjects, JetStream messages are
+// published asynchronously and retried until acknowledged
+type natsSink struct {
+	name    string
+	cfg     config.NATSSink
+	encoder Encoder
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	retries sync.WaitGroup // records being republished
+}
+
+// newNATSSink returns NATS sink connected to its servers, the sink keeps
+// reconnecting and buffers published records while server is unavailable
+func newNATSSink(cfg config.Sink, encoder Encoder) (*natsSink, error) {
+	n := cfg.NATS
+	s := &natsSink{name: cfg.Name, cfg: n, encoder: encoder}
+	opts := []nats.Option{
+		nats.Name("udp-collector " + cfg.Name),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.ReconnectWait(time.Duration(n.ReconnectWait) * time.Second),
+		nats.ReconnectBufSize(n.ReconnectBufSize),
+		nats.ConnectHandler(func(c *nats.Conn) {
+			slog.Info("connected to NATS server", "sink", cfg.Name, "url", c.ConnectedUrlRedacted())
+		}),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			slog.Warn("disconnected from NATS server", "sink", cfg.Name, "error", err)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			slog.Info("reconnected to NATS server", "sink", cfg.Name, "url", c.ConnectedUrlRedacted())
+		}),
+	}
+	switch {
+	case n.Username != "":
+		opts = append(opts, nats.UserInfo(n.Username, n.Password))
+	case n.Token != "":
+		opts = append(opts, nats.Token(n.Token))
+	case n.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(n.CredsFile))
+	}
+	if n.TLS != nil {
+		tlsConfig, err := clientTLSConfig(n.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+	conn, err := nats.Connect(n.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	if n.JetStream {
+		s.js, err = jetstream.New(conn,
+			jetstream.WithPublishAsyncMaxPending(n.MaxPending),
+			jetstream.WithPublishAsyncTimeout(time.Duration(n.AckTimeout)*time.Second),
+			jetstream.WithPublishAsyncErrHandler(s.failed))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Send implements Sink interface
+func (s *natsSink) Send(rec Record) error {
+	data, err := s.encoder.Encode(rec)
+	if err != nil {
+		return &encodeError{err}
+	}
+	subject := expandFields(s.cfg.Subject, rec, fieldToken)
+	if s.js == nil {
+		err = s.conn.Publish(subject, data)
+	} else {
+		var opts []jetstream.PublishOpt
+		if val, ok := rec[s.cfg.IDField]; ok && s.cfg.IDField != "" {
+			opts = append(opts, jetstream.WithMsgID(fmt.Sprint(val)))
+		}
+		_, err = s.js.PublishMsgAsync(&nats.Msg{Subject: subject, Data: data}, opts...)
+	}
+	if err != nil {
+		slog.Error("unable to send data", "sink", s.name, "subject", subject, "bytes", len(data), "error", err)
+		return err
+	}
+	return nil
+}
+
+// failed republishes JetStream message which was not acknowledged until
+// it runs out of retries, the message keeps its ID for deduplication
+func (s *natsSink) failed(js jetstream.JetStream, msg *nats.Msg, err error) {
+	attempt, _ := strconv.Atoi(msg.Header.Get(attemptHeader))
+	if attempt < s.cfg.Retries {
+		m := nats.NewMsg(msg.Subject)
+		m.Data = msg.Data
+		for key, val := range msg.Header {
+			m.Header[key] = val
+		}
+		m.Header.Set(attemptHeader, strconv.Itoa(attempt+1))
+		slog.Warn("retrying unacknowledged record", "sink", s.name, "subject", msg.Subject, "attempt", attempt+1, "error", err)
+		// the handler must not block acknowledgements processing
+		s.retries.Add(1)
+		go func() {
+			defer s.retries.Done()
+			if _, err := js.PublishMsgAsync(m); err != nil {
+				s.failed(js, m, err)
+			}
+		}()
+		return
+	}
+	deliveryErrors.WithLabelValues(s.name).Inc()
+	slog.Error("unable to deliver record", "sink", s.name, "subject", msg.Subject, "attempts", attempt+1, "error", err)
+}
+
+// Close implements Sink interface, it waits for pending acknowledgements
+// including the ones of republished records
+func (s *natsSink) Close() error {
+	timeout := time.Duration(s.cfg.AckTimeout) * time.Second
+	if s.js != nil {
+		deadline := time.After(time.Duration(s.cfg.Retries+1) * timeout)
+	wait:
+		for {
+			select {
+			case <-s.js.PublishAsyncComplete():
+			case <-deadline:
+				slog.Warn("closing sink with unacknowledged records", "sink", s.name, "records", s.js.PublishAsyncPending())
+				break wait
+			}
+			s.retries.Wait()
+			if s.js.PublishAsyncPending() == 0 {
+				break
+			}
+		}
+	}
+	err := s.conn.FlushTimeout(timeout)
+	s.conn.Close()
+	return err
+}
@@ -0,0 +1,238 @@
+package udpserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// runNATSServer starts in-process NATS server on given port, a random one
+// if port is -1, with JetStream storing streams in given directory if set
+func runNATSServer(t *testing.T, port int, storeDir string) *server.Server {
+	t.Helper()
+	opts := &server.Options{
+		Host:      "127.0.0.1",
+		Port:      port,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: storeDir != "",
+		StoreDir:  storeDir,
+	}
+	srv, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server is not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// newTestNATSSink returns NATS sink connected to given server
+func newTestNATSSink(t *testing.T, name string, srv *server.Server, cfg config.NATSSink) *natsSink {
+	t.Helper()
+	cfg.URL = srv.ClientURL()
+	cfg.MaxPending = 100
+	cfg.ReconnectBufSize = 1024 * 1024
+	if cfg.AckTimeout == 0 {
+		cfg.AckTimeout = 5
+	}
+	s, err := newNATSSink(config.Sink{Name: name, Type: "nats", NATS: cfg}, jsonEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// addStream creates JetStream stream capturing given subjects
+func addStream(t *testing.T, srv *server.Server, subjects ...string) jetstream.Stream {
+	t.Helper()
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	js, err := jetstream.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := js.CreateStream(context.Background(), jetstream.StreamConfig{
+		Name:       "UDP",
+		Subjects:   subjects,
+		Duplicates: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+// streamMessages returns number of messages in the stream
+func streamMessages(t *testing.T, stream jetstream.Stream) uint64 {
+	t.Helper()
+	info, err := stream.Info(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.State.Msgs
+}
+
+// TestNATSSubject checks expansion of record fields in subject and
+// escaping of their values
+func TestNATSSubject(t *testing.T) {
+	srv := runNATSServer(t, server.RANDOM_PORT, "")
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sub, err := conn.SubscribeSync("cmssw.udp.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Flush()
+
+	s := newTestNATSSink(t, "nats-subject", srv, config.NATSSink{Subject: "cmssw.udp.{site_name}.{app}"})
+	records := []Record{
+		{"site_name": "T2_CH_CERN", "app": "cmsRun"},
+		{"site_name": "T2.CH CERN", "app": "a/b*>#"},
+		{"site_name": "T1_US_FNAL"},
+	}
+	want := []string{
+		"cmssw.udp.T2_CH_CERN.cmsRun",
+		"cmssw.udp.T2_CH_CERN.a_b___",
+		"cmssw.udp.T1_US_FNAL.unknown",
+	}
+	for _, rec := range records {
+		if err := s.Send(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, subject := range want {
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("no message for %s: %v", subject, err)
+		}
+		if msg.Subject != subject {
+			t.Errorf("got subject %s, want %s", msg.Subject, subject)
+		}
+	}
+}
+
+// TestNATSJetStream checks that JetStream messages are acknowledged and
+// deduplicated by record ID
+func TestNATSJetStream(t *testing.T) {
+	srv := runNATSServer(t, server.RANDOM_PORT, t.TempDir())
+	stream := addStream(t, srv, "cmssw.udp.>")
+	name := "nats-jetstream"
+	before := testutil.ToFloat64(deliveryErrors.WithLabelValues(name))
+
+	s := newTestNATSSink(t, name, srv, config.NATSSink{
+		Subject:   "cmssw.udp.{site_name}",
+		JetStream: true,
+		IDField:   "unique_id",
+		Retries:   3,
+	})
+	for _, id := range []string{"a1", "a2", "a3", "a2"} {
+		if err := s.Send(Record{"site_name": "T2_CH_CERN", "unique_id": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := streamMessages(t, stream); n != 3 {
+		t.Errorf("stream has %d messages, want 3", n)
+	}
+	if n := testutil.ToFloat64(deliveryErrors.WithLabelValues(name)) - before; n != 0 {
+		t.Errorf("got %v delivery errors, want 0", n)
+	}
+}
+
+// TestNATSJetStreamDeliveryErrors checks that messages which are never
+// acknowledged are retried and then counted as delivery errors
+func TestNATSJetStreamDeliveryErrors(t *testing.T) {
+	srv := runNATSServer(t, server.RANDOM_PORT, t.TempDir())
+	addStream(t, srv, "cmssw.udp.>")
+	name := "nats-errors"
+	before := testutil.ToFloat64(deliveryErrors.WithLabelValues(name))
+
+	// there is no stream capturing the subject
+	s := newTestNATSSink(t, name, srv, config.NATSSink{Subject: "other.udp", JetStream: true, Retries: 1, AckTimeout: 1})
+	for i := 0; i < 2; i++ {
+		if err := s.Send(Record{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.ToFloat64(deliveryErrors.WithLabelValues(name)) - before; n != 2 {
+		t.Errorf("got %v delivery errors, want 2", n)
+	}
+}
+
+// TestNATSReconnect checks that records sent while server is unavailable
+// are buffered and published after reconnect, the stream keeps records
+// acknowledged before server restart
+func TestNATSReconnect(t *testing.T) {
+	dir := t.TempDir()
+	srv := runNATSServer(t, server.RANDOM_PORT, dir)
+	addStream(t, srv, "cmssw.udp.>")
+	port := srv.Addr().(*net.TCPAddr).Port
+
+	s := newTestNATSSink(t, "nats-reconnect", srv, config.NATSSink{
+		Subject:   "cmssw.udp.{site_name}",
+		JetStream: true,
+		IDField:   "unique_id",
+		Retries:   3,
+	})
+	if err := s.Send(Record{"site_name": "T2_CH_CERN", "unique_id": "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	<-s.js.PublishAsyncComplete()
+	srv.Shutdown()
+	srv.WaitForShutdown()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.conn.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, id := range []string{"a2", "a3"} {
+		if err := s.Send(Record{"site_name": "T2_CH_CERN", "unique_id": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv = runNATSServer(t, port, dir)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	js, err := jetstream.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := js.Stream(context.Background(), "UDP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := streamMessages(t, stream); n != 3 {
+		t.Errorf("stream has %d messages, want 3", n)
+	}
+}
@@ -0,0 +1,208 @@
+package udpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/lestrrat-go/strftime"
+)
+
+// bulkItem is a document of bulk request with its action line
+type bulkItem struct {
+	action []byte
+	doc    []byte
+}
+
+// bulkAction describes index action of bulk request
+type bulkAction struct {
+	Index struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id,omitempty"`
+	} `json:"index"`
+}
+
+// bulkResponse describes response of bulk request
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// openSearchSink indexes records with OpenSearch or Elasticsearch bulk API,
+// records are batched and delivered asynchronously
+type openSearchSink struct {
+	name    string
+	cfg     config.OpenSearchSink
+	encoder Encoder
+	index   *strftime.Strftime
+	client  *http.Client
+	batcher *batcher[bulkItem]
+}
+
+// newOpenSearchSink returns sink indexing records into configured cluster
+func newOpenSearchSink(cfg config.Sink, encoder Encoder) (*openSearchSink, error) {
+	o := cfg.OpenSearch
+	index, err := strftime.New(o.Index)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index %q: %w", o.Index, err)
+	}
+	client, err := sinkHTTPClient(o.TLS, time.Duration(o.Timeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	s := &openSearchSink{name: cfg.Name, cfg: o, encoder: encoder, index: index, client: client}
+	s.batcher = newBatcher(cfg.Name, o.BatchSize, time.Duration(o.FlushInterval)*time.Millisecond, s.flush)
+	return s, nil
+}
+
+// Send implements Sink interface
+func (s *openSearchSink) Send(rec Record) error {
+	doc, err := s.encoder.Encode(rec)
+	if err != nil {
+		return &encodeError{err}
+	}
+	var a bulkAction
+	a.Index.Index = s.index.FormatString(recordTime(rec, s.cfg.TimeField).UTC())
+	if val, ok := rec[s.cfg.IDField]; ok && s.cfg.IDField != "" {
+		a.Index.ID = fmt.Sprint(val)
+	}
+	action, err := json.Marshal(a)
+	if err != nil {
+		return &encodeError{err}
+	}
+	s.batcher.add(bulkItem{action: action, doc: doc})
+	return nil
+}
+
+// Close implements Sink interface, it flushes pending batches
+func (s *openSearchSink) Close() error {
+	s.batcher.close()
+	return nil
+}
+
+// flush sends batch to bulk API retrying rejected requests and items
+func (s *openSearchSink) flush(batch []bulkItem) {
+	backoff := time.Duration(s.cfg.RetryBackoff) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		retry, err := s.bulk(batch)
+		if len(retry) == 0 {
+			return
+		}
+		if attempt == s.cfg.Retries {
+			deliveryErrors.WithLabelValues(s.name).Add(float64(len(retry)))
+			slog.Error("unable to index records", "sink", s.name, "records", len(retry), "attempts", attempt+1, "error", err)
+			return
+		}
+		slog.Warn("retrying bulk request", "sink", s.name, "records", len(retry), "attempt", attempt+1, "delay", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+		batch = retry
+	}
+}
+
+// bulk sends batch to bulk API and returns items to retry, items which are
+// rejected permanently are counted as delivery errors
+func (s *openSearchSink) bulk(batch []bulkItem) ([]bulkItem, error) {
+	var body bytes.Buffer
+	for _, item := range batch {
+		body.Write(item.action)
+		body.WriteByte('\n')
+		body.Write(item.doc)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(s.cfg.URL, "/")+"/_bulk", &body)
+	if err != nil {
+		return batch, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	} else if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return batch, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return batch, err
+	}
+	if retryableStatus(resp.StatusCode) {
+		return batch, fmt.Errorf("bulk request failed with status %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= 300 {
+		deliveryErrors.WithLabelValues(s.name).Add(float64(len(batch)))
+		slog.Error("bulk request rejected", "sink", s.name, "records", len(batch), "status", resp.StatusCode, "response", truncate(data, 512))
+		return nil, nil
+	}
+
+	var r bulkResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		return batch, fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if !r.Errors {
+		return nil, nil
+	}
+	var retry []bulkItem
+	var failed int
+	var reason string
+	for i, item := range r.Items {
+		if i >= len(batch) {
+			break
+		}
+		for _, res := range item {
+			if retryableStatus(res.Status) {
+				retry = append(retry, batch[i])
+			} else if res.Error != nil || res.Status >= 300 {
+				failed++
+				if reason == "" && res.Error != nil {
+					reason = res.Error.Type + ": " + res.Error.Reason
+				}
+			}
+		}
+	}
+	if failed > 0 {
+		deliveryErrors.WithLabelValues(s.name).Add(float64(failed))
+		slog.Error("bulk items rejected", "sink", s.name, "records", failed, "error", reason)
+	}
+	if len(retry) > 0 {
+		return retry, fmt.Errorf("%d bulk items rejected with retryable status", len(retry))
+	}
+	return nil, nil
+}
+
+// retryableStatus checks whether request rejected with given HTTP status may be retried
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// recordTime returns time of the record from given field with unix time
+// in seconds, current time is used if field is not set
+func recordTime(rec Record, field string) time.Time {
+	if t, ok := unixTime(rec[field]); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// truncate returns data truncated to given size for logging
+func truncate(data []byte, size int) string {
+	if len(data) > size {
+		return string(data[:size]) + "..."
+	}
+	return string(data)
+}
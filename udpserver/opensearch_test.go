@@ -0,0 +1,276 @@
+package udpserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// bulkRequest is a bulk request received by fake OpenSearch cluster
+type bulkRequest struct {
+	header  http.Header
+	actions []bulkAction
+	docs    []Record
+}
+
+// fakeOpenSearch is a stand-in of OpenSearch bulk API, respond returns
+// status and item statuses of n-th request
+type fakeOpenSearch struct {
+	*httptest.Server
+	respond  func(n int, req bulkRequest) (int, []int)
+	mu       sync.Mutex
+	requests []bulkRequest
+}
+
+// newFakeOpenSearch starts fake OpenSearch cluster
+func newFakeOpenSearch(t *testing.T, respond func(n int, req bulkRequest) (int, []int)) *fakeOpenSearch {
+	f := &fakeOpenSearch{respond: respond}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.bulk))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// bulk handles bulk API request
+func (f *fakeOpenSearch) bulk(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	req := bulkRequest{header: r.Header}
+	scanner := bufio.NewScanner(r.Body)
+	for line := 0; scanner.Scan(); line++ {
+		if line%2 == 0 {
+			var a bulkAction
+			if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.actions = append(req.actions, a)
+			continue
+		}
+		var doc Record
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.docs = append(req.docs, doc)
+	}
+	f.mu.Lock()
+	n := len(f.requests)
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+
+	status, items := f.respond(n, req)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	res := map[string]interface{}{"errors": false}
+	var resItems []interface{}
+	for i := range req.actions {
+		item := map[string]interface{}{"status": http.StatusCreated}
+		if i < len(items) && items[i] >= 300 {
+			res["errors"] = true
+			item["status"] = items[i]
+			item["error"] = map[string]string{"type": "test_exception", "reason": fmt.Sprintf("status %d", items[i])}
+		}
+		resItems = append(resItems, map[string]interface{}{"index": item})
+	}
+	res["items"] = resItems
+	json.NewEncoder(w).Encode(res)
+}
+
+// accepted responds with all items indexed
+func accepted(int, bulkRequest) (int, []int) { return http.StatusOK, nil }
+
+// newTestOpenSearchSink returns sink indexing records into given cluster
+func newTestOpenSearchSink(t *testing.T, name, url string, cfg config.OpenSearchSink) *openSearchSink {
+	t.Helper()
+	cfg.URL = url
+	if cfg.Index == "" {
+		cfg.Index = "cmssw-udp"
+	}
+	cfg.BatchSize = 100
+	cfg.FlushInterval = 1000
+	cfg.Timeout = 5
+	cfg.Retries = 3
+	cfg.RetryBackoff = 1
+	s, err := newOpenSearchSink(config.Sink{Name: name, Type: "opensearch", OpenSearch: cfg}, jsonEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// sendRecords sends records to the sink and flushes them
+func sendRecords(t *testing.T, s Sink, records ...Record) {
+	t.Helper()
+	for _, rec := range records {
+		if err := s.Send(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOpenSearchBulk checks bulk request body, index name of record time
+// and document ids
+func TestOpenSearchBulk(t *testing.T) {
+	f := newFakeOpenSearch(t, accepted)
+	s := newTestOpenSearchSink(t, "os-bulk", f.URL, config.OpenSearchSink{
+		Index:     "cmssw-udp-%Y.%m.%d",
+		TimeField: "end_time",
+		IDField:   "unique_id",
+	})
+	sendRecords(t, s,
+		Record{"unique_id": "a1", "end_time": float64(1700000000), "site_name": "T2_CH_CERN"},
+		Record{"unique_id": "a2", "end_time": float64(1700092800)},
+		Record{"end_time": float64(1700092800)})
+
+	if len(f.requests) != 1 {
+		t.Fatalf("got %d bulk requests, want 1", len(f.requests))
+	}
+	req := f.requests[0]
+	if len(req.actions) != 3 || len(req.docs) != 3 {
+		t.Fatalf("got %d actions and %d documents, want 3", len(req.actions), len(req.docs))
+	}
+	want := []struct{ index, id string }{
+		{"cmssw-udp-2023.11.14", "a1"},
+		{"cmssw-udp-2023.11.16", "a2"},
+		{"cmssw-udp-2023.11.16", ""},
+	}
+	for i, w := range want {
+		if a := req.actions[i].Index; a.Index != w.index || a.ID != w.id {
+			t.Errorf("got action %+v, want index %s and id %q", a, w.index, w.id)
+		}
+	}
+	if req.docs[0]["site_name"] != "T2_CH_CERN" {
+		t.Errorf("unexpected document %v", req.docs[0])
+	}
+}
+
+// TestOpenSearchRetry checks that rejected requests and items are retried
+func TestOpenSearchRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		respond  func(n int, req bulkRequest) (int, []int)
+		requests int   // expected number of requests
+		retried  []int // expected number of documents in each request
+	}{
+		{"too many requests", func(n int, _ bulkRequest) (int, []int) {
+			if n == 0 {
+				return http.StatusTooManyRequests, nil
+			}
+			return http.StatusOK, nil
+		}, 2, []int{3, 3}},
+		{"server error", func(n int, _ bulkRequest) (int, []int) {
+			if n < 2 {
+				return http.StatusServiceUnavailable, nil
+			}
+			return http.StatusOK, nil
+		}, 3, []int{3, 3, 3}},
+		{"rejected items", func(n int, _ bulkRequest) (int, []int) {
+			if n == 0 {
+				return http.StatusOK, []int{http.StatusCreated, http.StatusTooManyRequests, http.StatusTooManyRequests}
+			}
+			return http.StatusOK, nil
+		}, 2, []int{3, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "os-retry-" + tt.name
+			before := testutil.ToFloat64(deliveryErrors.WithLabelValues(name))
+			f := newFakeOpenSearch(t, tt.respond)
+			s := newTestOpenSearchSink(t, name, f.URL, config.OpenSearchSink{IDField: "unique_id"})
+			sendRecords(t, s, Record{"unique_id": "a1"}, Record{"unique_id": "a2"}, Record{"unique_id": "a3"})
+
+			if len(f.requests) != tt.requests {
+				t.Fatalf("got %d bulk requests, want %d", len(f.requests), tt.requests)
+			}
+			for i, n := range tt.retried {
+				if len(f.requests[i].docs) != n {
+					t.Errorf("request %d has %d documents, want %d", i, len(f.requests[i].docs), n)
+				}
+			}
+			if tt.name == "rejected items" && f.requests[1].actions[0].Index.ID != "a2" {
+				t.Errorf("retried %+v, want a2 and a3", f.requests[1].actions)
+			}
+			if n := testutil.ToFloat64(deliveryErrors.WithLabelValues(name)) - before; n != 0 {
+				t.Errorf("got %v delivery errors, want 0", n)
+			}
+		})
+	}
+}
+
+// TestOpenSearchDeliveryErrors checks that permanently rejected items and
+// the ones failing after all retries are counted as delivery errors
+func TestOpenSearchDeliveryErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		respond  func(n int, req bulkRequest) (int, []int)
+		requests int
+		errors   float64
+	}{
+		{"rejected items", func(int, bulkRequest) (int, []int) {
+			return http.StatusOK, []int{http.StatusBadRequest, http.StatusCreated, http.StatusBadRequest}
+		}, 1, 2},
+		{"rejected request", func(int, bulkRequest) (int, []int) {
+			return http.StatusBadRequest, nil
+		}, 1, 3},
+		{"retries exhausted", func(int, bulkRequest) (int, []int) {
+			return http.StatusServiceUnavailable, nil
+		}, 4, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "os-errors-" + tt.name
+			before := testutil.ToFloat64(deliveryErrors.WithLabelValues(name))
+			f := newFakeOpenSearch(t, tt.respond)
+			s := newTestOpenSearchSink(t, name, f.URL, config.OpenSearchSink{})
+			sendRecords(t, s, Record{"n": 1}, Record{"n": 2}, Record{"n": 3})
+
+			if len(f.requests) != tt.requests {
+				t.Errorf("got %d bulk requests, want %d", len(f.requests), tt.requests)
+			}
+			if n := testutil.ToFloat64(deliveryErrors.WithLabelValues(name)) - before; n != tt.errors {
+				t.Errorf("got %v delivery errors, want %v", n, tt.errors)
+			}
+		})
+	}
+}
+
+// TestOpenSearchAuth checks basic and bearer authentication of requests
+func TestOpenSearchAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.OpenSearchSink
+		want string
+	}{
+		{"none", config.OpenSearchSink{}, ""},
+		{"basic", config.OpenSearchSink{Username: "monit", Password: "secret"}, "Basic bW9uaXQ6c2VjcmV0"},
+		{"bearer", config.OpenSearchSink{Token: "token", Username: "monit", Password: "secret"}, "Bearer token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeOpenSearch(t, accepted)
+			s := newTestOpenSearchSink(t, "os-auth-"+tt.name, f.URL, tt.cfg)
+			sendRecords(t, s, Record{"n": 1})
+
+			if len(f.requests) != 1 {
+				t.Fatalf("got %d bulk requests, want 1", len(f.requests))
+			}
+			if got := f.requests[0].header.Get("Authorization"); got != tt.want {
+				t.Errorf("got Authorization %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
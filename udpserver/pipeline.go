@@ -0,0 +1,130 @@
+package udpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// pipeline decodes, transforms and delivers packets received by a listener
+type pipeline struct {
+	name        string          // listener name
+	last        config.Listener // last known listener configuration
+	decoder     Decoder
+	decoderName string
+}
+
+// newPipeline returns pipeline of given listener
+func newPipeline(l config.Listener) *pipeline {
+	return &pipeline{name: l.Name, last: l}
+}
+
+// listener returns current listener configuration, the last known one
+// is used if listener is no longer configured
+func (p *pipeline) listener(cfg *config.Configuration) *config.Listener {
+	if l := cfg.Listener(p.name); l != nil {
+		p.last = *l
+	}
+	return &p.last
+}
+
+// result describes outcome of packet processing
+type result struct {
+	accepted   int  // number of records passed to sinks
+	rejected   int  // number of rejected records
+	incomplete bool // the last record is incomplete, e.g. truncated
+}
+
+// packet describes data received by a listener
+type packet struct {
+	remote   string         // canonical source address
+	source   netip.AddrPort // source address
+	received time.Time      // receive time
+	data     []byte         // received data
+	fields   Record         // fields added to every record of the packet
+}
+
+// handle processes received packet
+func (p *pipeline) handle(cfg *config.Configuration, pkt packet) result {
+	l := p.listener(cfg)
+	remote, data := pkt.remote, pkt.data
+	receivedPackets.WithLabelValues(p.name).Inc()
+
+	// decompress the data if it is compressed
+	payload, err := decompress(cfg, p.name, data)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		reject(cfg, p.name, remote, reasonTruncated, data, err)
+		return result{rejected: 1, incomplete: true}
+	} else if err != nil {
+		// corrupt or too large payload can't be fixed by larger buffer
+		reject(cfg, p.name, remote, reasonDecompress, data, err)
+		return result{rejected: 1}
+	}
+
+	// dump message to our log
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		sdata := strings.TrimSpace(string(payload))
+		slog.Debug("received packet", "listener", p.name, "remote", remote, "bytes", len(data), "data", sdata)
+	}
+
+	// pick up decoder of the data, JSON by default
+	if p.decoder == nil || l.Decoder != p.decoderName {
+		decoder, err := NewDecoder(l.Decoder)
+		if err != nil {
+			slog.Error("unable to create decoder", "listener", p.name, "decoder", l.Decoder, "error", err)
+			return result{}
+		}
+		// HTTP requests may carry batches of records as JSON arrays
+		if _, ok := decoder.(jsonDecoder); ok && l.Protocol == "http" {
+			decoder = jsonDecoder{arrays: true}
+		}
+		p.decoder = decoder
+		p.decoderName = l.Decoder
+	}
+
+	// decode the data into records and process each of them
+	records, errs := p.decoder.Decode(remote, payload)
+	recordsPerDatagram.WithLabelValues(p.name).Observe(float64(len(records) + len(errs)))
+	receivedRecords.WithLabelValues(p.name).Add(float64(len(records)))
+	for _, derr := range errs {
+		reject(cfg, p.name, remote, derr.Reason, derr.Data, derr.Err)
+	}
+	for _, rec := range records {
+		for key, val := range pkt.fields {
+			rec[key] = val
+		}
+		transform(l.Transforms, rec)
+		addMetadata(cfg, p.name, pkt, rec)
+		deliver(cfg, l, remote, rec)
+	}
+	return result{
+		accepted:   len(records),
+		rejected:   len(errs),
+		incomplete: len(errs) > 0 && errs[len(errs)-1].Reason == reasonTruncated,
+	}
+}
+
+// transform applies listener transformations to given record
+func transform(t *config.Transforms, rec Record) {
+	if t == nil {
+		return
+	}
+	for from, to := range t.Rename {
+		if val, ok := rec[from]; ok {
+			rec[to] = val
+			delete(rec, from)
+		}
+	}
+	for _, key := range t.Drop {
+		delete(rec, key)
+	}
+	for key, val := range t.Set {
+		rec[key] = val
+	}
+}
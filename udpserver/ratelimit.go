@@ -0,0 +1,215 @@
+package udpserver
+
+import (
+	"container/list"
+	"net/netip"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// tokenBucket implements token-bucket rate limit
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket refilled with given rate up to burst
+func (b *tokenBucket) allow(now time.Time, rate float64, burst int) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+	}
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// subnetBucket holds rate limit of a network
+type subnetBucket struct {
+	limit  config.SubnetLimit
+	prefix netip.Prefix
+	bucket tokenBucket
+}
+
+// Offender describes source address which exceeded the rate limit
+type Offender struct {
+	Source  string `json:"source"`  // source address
+	Limit   string `json:"limit"`   // last exceeded limit: source, subnet or global
+	Passed  uint64 `json:"passed"`  // number of passed packets
+	Limited uint64 `json:"limited"` // number of packets over the limit
+	Sampled uint64 `json:"sampled"` // number of packets over the limit which were kept
+}
+
+// sourceEntry holds rate limit state of a source address
+type sourceEntry struct {
+	addr     netip.Addr
+	bucket   tokenBucket
+	offender Offender
+}
+
+// rateLimiter limits packets per source address, per subnet and globally,
+// the table of source addresses is bounded by evicting least recently
+// seen sources
+type rateLimiter struct {
+	mu      sync.Mutex
+	cfg     config.RateLimit
+	global  tokenBucket
+	subnets []*subnetBucket
+	lru     *list.List // sourceEntry items, most recently seen first
+	sources map[netip.Addr]*list.Element
+}
+
+// limiter is the rate limiter of all listeners
+var limiter = &rateLimiter{lru: list.New(), sources: make(map[netip.Addr]*list.Element)}
+
+// update applies rate limits of given configuration, state of unchanged
+// subnet limits is kept
+func (r *rateLimiter) update(cfg *config.Configuration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var subnets []*subnetBucket
+	for _, s := range cfg.RateLimit.Subnets {
+		prefix, err := config.ParseNetwork(s.Network)
+		if err != nil {
+			continue
+		}
+		sb := &subnetBucket{limit: s, prefix: prefix}
+		for _, old := range r.subnets {
+			if reflect.DeepEqual(old.limit, s) {
+				sb.bucket = old.bucket
+			}
+		}
+		subnets = append(subnets, sb)
+	}
+	r.subnets = subnets
+	r.cfg = cfg.RateLimit
+	r.evict()
+}
+
+// evict removes least recently seen sources above the table size
+func (r *rateLimiter) evict() {
+	for r.lru.Len() > r.cfg.MaxSources {
+		e := r.lru.Back()
+		delete(r.sources, e.Value.(*sourceEntry).addr)
+		r.lru.Remove(e)
+	}
+}
+
+// enabled checks if any rate limit is configured
+func (r *rateLimiter) enabled() bool {
+	return r.cfg.Rate > 0 || r.cfg.GlobalRate > 0 || len(r.subnets) > 0
+}
+
+// allow checks if packet of given source address is within rate limits,
+// it returns exceeded limit and whether the packet is kept as a sample
+func (r *rateLimiter) allow(addr netip.Addr, now time.Time) (limit string, keep bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.enabled() {
+		return "", true
+	}
+	addr = addr.Unmap()
+	var entry *sourceEntry
+	if e, ok := r.sources[addr]; ok {
+		r.lru.MoveToFront(e)
+		entry = e.Value.(*sourceEntry)
+	} else {
+		entry = &sourceEntry{addr: addr, offender: Offender{Source: addr.String()}}
+		r.sources[addr] = r.lru.PushFront(entry)
+		r.evict()
+	}
+
+	// the first exceeded limit is reported, further buckets keep their tokens
+	switch {
+	case r.cfg.Rate > 0 && !entry.bucket.allow(now, r.cfg.Rate, r.cfg.Burst):
+		limit = "source"
+	case !r.allowSubnet(addr, now):
+		limit = "subnet"
+	case r.cfg.GlobalRate > 0 && !r.global.allow(now, r.cfg.GlobalRate, r.cfg.GlobalBurst):
+		limit = "global"
+	}
+	if limit == "" {
+		entry.offender.Passed++
+		return "", true
+	}
+	entry.offender.Limit = limit
+	entry.offender.Limited++
+	if r.cfg.Action == "sample" && (entry.offender.Limited-1)%uint64(r.cfg.SampleRate) == 0 {
+		entry.offender.Sampled++
+		return limit, true
+	}
+	return limit, false
+}
+
+// allowSubnet checks rate limits of all networks which contain given address
+func (r *rateLimiter) allowSubnet(addr netip.Addr, now time.Time) bool {
+	for _, s := range r.subnets {
+		if s.prefix.Contains(addr) && !s.bucket.allow(now, s.limit.Rate, s.limit.Burst) {
+			return false
+		}
+	}
+	return true
+}
+
+// top returns given number of sources with the most packets over the limit
+func (r *rateLimiter) top(n int) []Offender {
+	r.mu.Lock()
+	var offenders []Offender
+	for e := r.lru.Front(); e != nil; e = e.Next() {
+		if o := e.Value.(*sourceEntry).offender; o.Limited > 0 {
+			offenders = append(offenders, o)
+		}
+	}
+	r.mu.Unlock()
+	sort.Slice(offenders, func(i, j int) bool {
+		return offenders[i].Limited > offenders[j].Limited
+	})
+	if len(offenders) > n {
+		offenders = offenders[:n]
+	}
+	return offenders
+}
+
+// TopOffenders returns up to n sources with the most packets over the
+// rate limits
+func TopOffenders(n int) []Offender {
+	return limiter.top(n)
+}
+
+// rateLimited checks rate limits of packet received by given listener,
+// it returns true if the packet must be dropped
+func rateLimited(listener string, addr netip.Addr) bool {
+	limit, keep := limiter.allow(addr, time.Now())
+	if limit == "" {
+		return false
+	}
+	action := "drop"
+	if keep {
+		action = "sample"
+	}
+	rateLimitedPackets.WithLabelValues(listener, limit, action).Inc()
+	return !keep
+}
+
+// exportOffenders periodically exports top offenders as metrics
+func exportOffenders() {
+	for {
+		offenders := TopOffenders(store.Get().RateLimit.TopOffenders)
+		topOffenders.Reset()
+		for _, o := range offenders {
+			topOffenders.WithLabelValues(o.Source).Set(float64(o.Limited))
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
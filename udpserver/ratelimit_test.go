@@ -0,0 +1,154 @@
+package udpserver
+
+import (
+	"container/list"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// newTestLimiter returns rate limiter with given limits and defaults
+func newTestLimiter(limits config.RateLimit) *rateLimiter {
+	cfg := &config.Configuration{RateLimit: limits}
+	cfg.SetDefaults()
+	r := &rateLimiter{lru: list.New(), sources: make(map[netip.Addr]*list.Element)}
+	r.update(cfg)
+	return r
+}
+
+// TestTokenBucket checks initial burst, refill by rate and cap of tokens
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	start := time.Now()
+	steps := []struct {
+		after time.Duration
+		want  bool
+	}{
+		{0, true},
+		{0, true},
+		{0, true},
+		{0, false}, // burst is used up
+		{499 * time.Millisecond, false},
+		{500 * time.Millisecond, true}, // one token refilled
+		{500 * time.Millisecond, false},
+		{time.Minute, true}, // refilled up to burst only
+		{time.Minute, true},
+		{time.Minute, true},
+		{time.Minute, false},
+	}
+	for i, s := range steps {
+		if got := b.allow(start.Add(s.after), 2, 3); got != s.want {
+			t.Errorf("step %d after %v: got %v, want %v", i, s.after, got, s.want)
+		}
+	}
+}
+
+// TestRateLimits checks source, subnet and global limits and the limit
+// reported for dropped packets
+func TestRateLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  config.RateLimit
+		sources []string // source of every packet sent at the same time
+		want    []string // exceeded limit of every packet
+	}{
+		{"disabled", config.RateLimit{},
+			[]string{"192.0.2.1", "192.0.2.1", "192.0.2.1"}, []string{"", "", ""}},
+		{"source", config.RateLimit{Rate: 1, Burst: 2},
+			[]string{"192.0.2.1", "192.0.2.1", "192.0.2.2", "192.0.2.1", "192.0.2.2", "192.0.2.2"},
+			[]string{"", "", "", "source", "", "source"}},
+		{"IPv4-mapped source", config.RateLimit{Rate: 1, Burst: 1},
+			[]string{"192.0.2.1", "::ffff:192.0.2.1"}, []string{"", "source"}},
+		{"subnet", config.RateLimit{Subnets: []config.SubnetLimit{{Network: "192.0.2.0/24", Rate: 1, Burst: 2}}},
+			[]string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "198.51.100.1"}, []string{"", "", "subnet", ""}},
+		{"global", config.RateLimit{GlobalRate: 1, GlobalBurst: 2},
+			[]string{"192.0.2.1", "198.51.100.1", "2001:db8::1"}, []string{"", "", "global"}},
+		{"source before global", config.RateLimit{Rate: 1, Burst: 1, GlobalRate: 1, GlobalBurst: 2},
+			[]string{"192.0.2.1", "192.0.2.1", "192.0.2.2", "192.0.2.3"}, []string{"", "source", "", "global"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestLimiter(tt.limits)
+			now := time.Now()
+			for i, src := range tt.sources {
+				limit, keep := r.allow(netip.MustParseAddr(src), now)
+				if limit != tt.want[i] || keep != (limit == "") {
+					t.Errorf("packet %d from %s: got limit %q and keep %v, want %q", i, src, limit, keep, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestRateLimitSample checks that one of sampleRate packets over the limit
+// is kept and counted
+func TestRateLimitSample(t *testing.T) {
+	r := newTestLimiter(config.RateLimit{Rate: 1, Burst: 1, Action: "sample", SampleRate: 3})
+	now := time.Now()
+	addr := netip.MustParseAddr("192.0.2.1")
+	want := []bool{true, true, false, false, true, false, false, true}
+	for i, w := range want {
+		if _, keep := r.allow(addr, now); keep != w {
+			t.Errorf("packet %d: got keep %v, want %v", i, keep, w)
+		}
+	}
+	top := r.top(10)
+	if len(top) != 1 || top[0].Passed != 1 || top[0].Limited != 7 || top[0].Sampled != 3 {
+		t.Errorf("unexpected offenders %+v", top)
+	}
+}
+
+// TestRateLimitEviction checks that the least recently seen sources are
+// evicted above maxSources and start with full bucket when seen again
+func TestRateLimitEviction(t *testing.T) {
+	r := newTestLimiter(config.RateLimit{Rate: 1, Burst: 1, MaxSources: 2})
+	now := time.Now()
+	a := netip.MustParseAddr("192.0.2.1")
+	b := netip.MustParseAddr("192.0.2.2")
+	c := netip.MustParseAddr("192.0.2.3")
+	r.allow(a, now)
+	r.allow(b, now)
+	r.allow(a, now) // a is seen after b and over the limit
+	r.allow(c, now) // b is evicted
+	if len(r.sources) != 2 || r.lru.Len() != 2 {
+		t.Fatalf("got %d sources and %d in LRU list, want 2", len(r.sources), r.lru.Len())
+	}
+	if _, ok := r.sources[b]; ok {
+		t.Error("least recently seen source is kept")
+	}
+	if limit, _ := r.allow(a, now); limit != "source" {
+		t.Errorf("source kept in table got limit %q, want source", limit)
+	}
+	if limit, _ := r.allow(b, now); limit != "" {
+		t.Errorf("evicted source got limit %q, want full bucket", limit)
+	}
+
+	// shrinking table evicts sources on configuration update
+	cfg := &config.Configuration{RateLimit: config.RateLimit{Rate: 1, Burst: 1, MaxSources: 1}}
+	cfg.SetDefaults()
+	r.update(cfg)
+	if len(r.sources) != 1 {
+		t.Errorf("got %d sources after update, want 1", len(r.sources))
+	}
+}
+
+// TestTopOffenders checks ordering and number of top offenders
+func TestTopOffenders(t *testing.T) {
+	r := newTestLimiter(config.RateLimit{Rate: 1, Burst: 1})
+	now := time.Now()
+	for i, n := range []int{3, 1, 5, 0} {
+		addr := netip.AddrFrom4([4]byte{192, 0, 2, byte(i + 1)})
+		for j := 0; j <= n; j++ {
+			r.allow(addr, now)
+		}
+	}
+	top := r.top(2)
+	if len(top) != 2 || top[0].Source != "192.0.2.3" || top[0].Limited != 5 || top[1].Source != "192.0.2.1" {
+		t.Errorf("unexpected top offenders %+v", top)
+	}
+	if top := r.top(10); len(top) != 3 {
+		t.Errorf("got %d offenders, want 3 sources over the limit", len(top))
+	}
+}
@@ -0,0 +1,119 @@
+package udpserver
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/dmwm/udp-collector/logging"
+)
+
+// reasons to reject UDP packets
+const (
+	reasonInvalidJSON = "invalid_json" // packet is not valid JSON
+	reasonTruncated   = "truncated"    // packet did not fit into buffer
+	reasonMalformed   = "malformed"    // packet can't be decoded for other reasons
+	reasonMarshal     = "marshal"      // decoded packet can't be encoded back
+	reasonDecompress  = "decompress"   // compressed packet can't be decompressed
+	reasonDenied      = "denied"       // source address is not allowed
+	reasonAuth        = "auth"         // signature of the packet can't be verified
+	reasonUnsigned    = "unsigned"     // packet is not signed
+)
+
+// RejectedPacket describes UDP packet rejected by the server
+type RejectedPacket struct {
+	Time     time.Time `json:"time"`     // time when packet was received
+	Listener string    `json:"listener"` // name of listener which received the packet
+	Remote   string    `json:"remote"`   // source address of the packet
+	Reason   string    `json:"reason"`   // reason of rejection
+	Error    string    `json:"error"`    // error message
+	Bytes    int       `json:"bytes"`    // size of the packet
+	Payload  string    `json:"payload"`  // truncated payload of the packet
+}
+
+// RejectedPackets is a ring buffer of last rejected packets
+type RejectedPackets struct {
+	mu      sync.Mutex
+	packets []RejectedPacket
+	next    int
+	full    bool
+}
+
+// NewRejectedPackets returns new ring buffer of given size
+func NewRejectedPackets(size int) *RejectedPackets {
+	return &RejectedPackets{packets: make([]RejectedPacket, size)}
+}
+
+// Add adds packet to the buffer replacing the oldest one if buffer is full
+func (r *RejectedPackets) Add(p RejectedPacket) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.packets) == 0 {
+		return
+	}
+	r.packets[r.next] = p
+	r.next = (r.next + 1) % len(r.packets)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// List returns rejected packets from the oldest to the newest one
+func (r *RejectedPackets) List() []RejectedPacket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []RejectedPacket
+	if r.full {
+		out = append(out, r.packets[r.next:]...)
+	}
+	out = append(out, r.packets[:r.next]...)
+	return out
+}
+
+// Resize changes size of the buffer keeping the newest packets
+func (r *RejectedPackets) Resize(size int) {
+	packets := r.List()
+	if len(packets) > size {
+		packets = packets[len(packets)-size:]
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.packets = make([]RejectedPacket, size)
+	r.next = copy(r.packets, packets)
+	r.full = false
+	if size > 0 && r.next == size {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Rejected holds last rejected packets
+var Rejected = NewRejectedPackets(0)
+
+// rejectLimiter rate-limits logging of rejected packets per listener and reason
+var rejectLimiter = logging.NewLimiter(time.Minute)
+
+// reject records packet rejected by given listener, counts it and logs it
+// with rate-limit per listener and rejection reason
+func reject(cfg *config.Configuration, listener, remote, reason string, data []byte, err error) {
+	rejectedPackets.WithLabelValues(listener, reason).Inc()
+	payload := string(data)
+	if len(payload) > cfg.RejectedPayloadSize {
+		payload = payload[:cfg.RejectedPayloadSize] + "..."
+	}
+	now := time.Now()
+	Rejected.Add(RejectedPacket{
+		Time:     now,
+		Listener: listener,
+		Remote:   remote,
+		Reason:   reason,
+		Error:    err.Error(),
+		Bytes:    len(data),
+		Payload:  payload,
+	})
+	if ok, suppressed := rejectLimiter.Allow(listener+"|"+reason, now); ok {
+		slog.Warn("rejected packet", "listener", listener, "reason", reason, "remote", remote, "bytes", len(data), "suppressed", suppressed, "error", err)
+	}
+	slog.Debug("rejected packet payload", "listener", listener, "reason", reason, "remote", remote, "data", payload)
+}
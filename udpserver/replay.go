@@ -0,0 +1,270 @@
+package udpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/klauspost/compress/gzip"
+)
+
+// ReplayOptions describes replay of archived newline-delimited JSON records
+type ReplayOptions struct {
+	Listener    string            // listener whose transformations and sinks are used, the first one if empty
+	Files       []string          // files to replay, gzipped if they have .gz suffix
+	TimeField   string            // record field with unix time in seconds used by time range
+	From        time.Time         // replay records with time not before, unbounded if zero
+	To          time.Time         // replay records with time before, unbounded if zero
+	Match       map[string]string // field values records must have
+	Rate        float64           // records per second, unlimited if 0
+	Progress    time.Duration     // interval of progress reports
+	OffsetsFile string            // file to keep offsets of replayed files to resume from
+}
+
+// ReplayStats describes outcome of replay
+type ReplayStats struct {
+	Read        int // number of read records
+	Replayed    int // number of records passed to sinks
+	Skipped     int // number of records not matching filters
+	Invalid     int // number of lines which are not valid records
+	Failed      int // number of failed deliveries to sinks
+	Undelivered int // number of records sinks failed to deliver after accepting them
+}
+
+// replay keeps state of running replay
+type replay struct {
+	cfg      *config.Configuration
+	listener *config.Listener
+	opts     ReplayOptions
+	offsets  map[string]int64 // offsets of replayed data by file names
+	sinks    []string         // names of sinks of the listener
+	failed   float64          // delivery errors of the sinks before replay
+	stats    ReplayStats
+	started  time.Time
+	reported time.Time
+}
+
+// Replay reads records from given files and passes them through
+// transformations and sinks of a listener, the offsets of replayed data
+// are saved to resume replay after it is interrupted
+func Replay(ctx context.Context, cfg *config.Configuration, opts ReplayOptions) (ReplayStats, error) {
+	l := &cfg.Listeners[0]
+	if opts.Listener != "" {
+		if l = cfg.Listener(opts.Listener); l == nil {
+			return ReplayStats{}, fmt.Errorf("unknown listener %q", opts.Listener)
+		}
+	}
+	r := &replay{cfg: cfg, listener: l, opts: opts, offsets: make(map[string]int64)}
+	if err := r.loadOffsets(); err != nil {
+		return r.stats, err
+	}
+	r.sinks = l.Sinks
+	if len(r.sinks) == 0 {
+		for _, s := range cfg.Sinks {
+			r.sinks = append(r.sinks, s.Name)
+		}
+	}
+	r.failed = deliveryErrorsTotal(r.sinks)
+	updateSinks(cfg)
+
+	r.started = time.Now()
+	r.reported = r.started
+	var err error
+	for _, file := range opts.Files {
+		if err = r.replayFile(ctx, file); err != nil {
+			break
+		}
+	}
+	// offsets are saved once sinks flushed all records they accepted
+	cerr := closeSinks()
+	r.stats.Undelivered = r.undelivered()
+	switch {
+	case cerr != nil:
+		err = errors.Join(err, fmt.Errorf("unable to close sinks, offsets are not saved: %w", cerr))
+	case r.stats.Undelivered > 0:
+		err = errors.Join(err, fmt.Errorf("sinks failed to deliver %d records, offsets are not saved", r.stats.Undelivered))
+	default:
+		if serr := r.saveOffsets(); serr != nil {
+			err = errors.Join(err, serr)
+		}
+	}
+	r.report("replay finished", "")
+	return r.stats, err
+}
+
+// undelivered returns number of records sinks failed to deliver since
+// replay started
+func (r *replay) undelivered() int {
+	return int(deliveryErrorsTotal(r.sinks) - r.failed)
+}
+
+// replayFile replays records of given file starting from its saved offset
+func (r *replay) replayFile(ctx context.Context, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		defer zr.Close()
+		in = zr
+	}
+	offset := r.offsets[file]
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, in, offset); err != nil {
+			return fmt.Errorf("%s: unable to skip to offset %d: %w", file, offset, err)
+		}
+		slog.Info("resuming replay", "file", file, "offset", offset)
+	}
+
+	decoder := jsonDecoder{}
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			r.replayLine(file, decoder, line)
+			offset += int64(len(line))
+			r.offsets[file] = offset
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if err := r.wait(ctx); err != nil {
+			return err
+		}
+		if r.opts.Progress > 0 && time.Since(r.reported) >= r.opts.Progress {
+			r.report("replay progress", file)
+			// records may still be pending in sinks, but offsets are not
+			// moved past records which sinks already failed to deliver
+			if r.undelivered() > 0 {
+				continue
+			}
+			if err := r.saveOffsets(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// replayLine passes records of given line matching the filters to sinks
+func (r *replay) replayLine(file string, decoder Decoder, line []byte) {
+	if len(strings.TrimSpace(string(line))) == 0 {
+		return
+	}
+	records, errs := decoder.Decode(file, line)
+	for _, derr := range errs {
+		r.stats.Invalid++
+		reject(r.cfg, r.listener.Name, file, derr.Reason, derr.Data, derr.Err)
+	}
+	for _, rec := range records {
+		r.stats.Read++
+		if !r.matches(rec) {
+			r.stats.Skipped++
+			continue
+		}
+		transform(r.listener.Transforms, rec)
+		r.stats.Failed += deliver(r.cfg, r.listener, file, rec)
+		r.stats.Replayed++
+	}
+}
+
+// matches checks that record is within time range and has required field values
+func (r *replay) matches(rec Record) bool {
+	for key, val := range r.opts.Match {
+		if v, ok := rec[key]; !ok || fmt.Sprint(v) != val {
+			return false
+		}
+	}
+	if r.opts.From.IsZero() && r.opts.To.IsZero() {
+		return true
+	}
+	t, ok := unixTime(rec[r.opts.TimeField])
+	if !ok {
+		return false
+	}
+	if !r.opts.From.IsZero() && t.Before(r.opts.From) {
+		return false
+	}
+	return r.opts.To.IsZero() || t.Before(r.opts.To)
+}
+
+// wait paces replay according to its rate and checks for cancellation
+func (r *replay) wait(ctx context.Context) error {
+	var delay time.Duration
+	if r.opts.Rate > 0 {
+		next := r.started.Add(time.Duration(float64(r.stats.Replayed) / r.opts.Rate * float64(time.Second)))
+		delay = time.Until(next)
+	}
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// report logs replay progress
+func (r *replay) report(msg, file string) {
+	r.reported = time.Now()
+	elapsed := r.reported.Sub(r.started).Seconds()
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(r.stats.Replayed) / elapsed
+	}
+	slog.Info(msg, "file", file, "read", r.stats.Read, "replayed", r.stats.Replayed,
+		"skipped", r.stats.Skipped, "invalid", r.stats.Invalid, "failed", r.stats.Failed, "undelivered", r.stats.Undelivered,
+		"rate", fmt.Sprintf("%.1f", rate))
+}
+
+// loadOffsets reads offsets of previous replay if offsets file exists
+func (r *replay) loadOffsets() error {
+	if r.opts.OffsetsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(r.opts.OffsetsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &r.offsets); err != nil {
+		return fmt.Errorf("%s: %w", r.opts.OffsetsFile, err)
+	}
+	return nil
+}
+
+// saveOffsets writes offsets of replayed files
+func (r *replay) saveOffsets() error {
+	if r.opts.OffsetsFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.offsets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.opts.OffsetsFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.opts.OffsetsFile)
+}
@@ -0,0 +1,84 @@
+package udpserver
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// TestReplayMatchesTime checks time range filter of records with time of
+// numeric types produced by all decoders
+func TestReplayMatchesTime(t *testing.T) {
+	r := &replay{opts: ReplayOptions{TimeField: "end_time", From: time.Unix(100, 0), To: time.Unix(200, 0)}}
+	tests := []struct {
+		value interface{}
+		want  bool
+	}{
+		{float64(150), true},
+		{int64(150), true},
+		{uint64(150), true},
+		{int8(120), true},
+		{uint16(250), false},
+		{int32(50), false},
+		{"150", false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := r.matches(Record{"end_time": tt.value}); got != tt.want {
+			t.Errorf("%T %v: got %v, want %v", tt.value, tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestReplayOffsets checks that offsets are saved only when sinks
+// delivered all replayed records
+func TestReplayOffsets(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "records.jsonl")
+	data := "{\"a\": 1}\n{\"a\": 2}\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		respond func(int, bulkRequest) (int, []int)
+		saved   bool
+	}{
+		{"rejected", func(int, bulkRequest) (int, []int) { return http.StatusBadRequest, nil }, false},
+		{"accepted", accepted, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeOpenSearch(t, tt.respond)
+			cfg := &config.Configuration{
+				Sinks: []config.Sink{{Name: "replay-" + tt.name, Type: "opensearch",
+					OpenSearch: config.OpenSearchSink{URL: f.URL, Index: "cmssw-udp"}}},
+			}
+			cfg.SetDefaults()
+			offsets := filepath.Join(dir, tt.name+".offsets")
+			stats, err := Replay(context.Background(), cfg, ReplayOptions{Files: []string{file}, OffsetsFile: offsets})
+			if stats.Replayed != 2 {
+				t.Errorf("replayed %d records, want 2", stats.Replayed)
+			}
+			saved, rerr := os.ReadFile(offsets)
+			if !tt.saved {
+				if err == nil || stats.Undelivered != 2 || rerr == nil {
+					t.Errorf("got error %v, %d undelivered records and offsets %s", err, stats.Undelivered, saved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(saved), `": 18`) {
+				t.Errorf("unexpected offsets %s", saved)
+			}
+		})
+	}
+}
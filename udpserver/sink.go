@@ -0,0 +1,253 @@
+package udpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// Sink delivers records to their destination
+type Sink interface {
+	Send(rec Record) error
+	Close() error
+}
+
+// encodeError is returned by sinks when record can't be encoded
+type encodeError struct {
+	err error
+}
+
+// Error implements error interface
+func (e *encodeError) Error() string { return e.err.Error() }
+
+// Unwrap returns encoding error
+func (e *encodeError) Unwrap() error { return e.err }
+
+// NewSink returns sink of given configuration
+func NewSink(cfg config.Sink) (Sink, error) {
+	encoder, err := NewEncoder(cfg.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Type {
+	case "stomp":
+		return newStompSink(cfg, encoder), nil
+	case "amqp":
+		return newAMQPSink(cfg, encoder), nil
+	case "file":
+		return newFileSink(cfg, encoder)
+	case "kafka":
+		return newKafkaSink(cfg, encoder)
+	case "opensearch":
+		return newOpenSearchSink(cfg, encoder)
+	case "webhook":
+		return newWebhookSink(cfg, encoder)
+	case "nats":
+		return newNATSSink(cfg, encoder)
+	}
+	return nil, fmt.Errorf("unsupported sink type %s", cfg.Type)
+}
+
+// sinkEntry holds running sink and its configuration
+type sinkEntry struct {
+	cfg  config.Sink
+	sink Sink
+}
+
+// running sinks by their names
+var sinks = make(map[string]*sinkEntry)
+var sinksMutex sync.RWMutex
+
+// sinksUpdate serializes updates of running sinks
+var sinksUpdate sync.Mutex
+
+// updateSinks starts sinks of given configuration, the sinks with
+// unchanged configuration keep running and removed sinks are closed.
+// New sinks are created and old ones closed without blocking delivery of
+// records, which only waits for the swap of running sinks.
+func updateSinks(cfg *config.Configuration) {
+	sinksUpdate.Lock()
+	defer sinksUpdate.Unlock()
+	sinksMutex.RLock()
+	current := maps.Clone(sinks)
+	sinksMutex.RUnlock()
+
+	updated := make(map[string]*sinkEntry)
+	stale := make(map[string]*sinkEntry)
+	for _, sc := range cfg.Sinks {
+		e, ok := current[sc.Name]
+		if ok && reflect.DeepEqual(e.cfg, sc) {
+			updated[sc.Name] = e
+			continue
+		}
+		sink, err := NewSink(sc)
+		if err != nil {
+			slog.Error("unable to create sink", "sink", sc.Name, "type", sc.Type, "error", err)
+			if ok {
+				updated[sc.Name] = e
+			}
+			continue
+		}
+		if ok {
+			slog.Info("sink parameters changed, restarting", "sink", sc.Name)
+			stale[sc.Name] = e
+		}
+		updated[sc.Name] = &sinkEntry{cfg: sc, sink: sink}
+	}
+	for name, e := range current {
+		if _, ok := updated[name]; !ok {
+			slog.Info("sink removed", "sink", name)
+			stale[name] = e
+		}
+	}
+
+	sinksMutex.Lock()
+	sinks = updated
+	sinksMutex.Unlock()
+	// old sinks may take long to flush pending records
+	go func() {
+		for name, e := range stale {
+			closeSink(name, e.sink)
+		}
+	}()
+}
+
+// deliver sends record received by given listener to its sinks and returns
+// number of failed sinks, all sinks are used if listener does not specify them.
+// The lock is held only to look up the sinks, so that slow sinks do not
+// block updates of running sinks.
+func deliver(cfg *config.Configuration, l *config.Listener, remote string, rec Record) int {
+	names := l.Sinks
+	if len(names) == 0 {
+		for _, s := range cfg.Sinks {
+			names = append(names, s.Name)
+		}
+	}
+	targets := make([]*sinkEntry, 0, len(names))
+	sinksMutex.RLock()
+	for _, name := range names {
+		if e, ok := sinks[name]; ok {
+			targets = append(targets, e)
+		}
+	}
+	sinksMutex.RUnlock()
+
+	var failed int
+	for _, e := range targets {
+		name := e.cfg.Name
+		err := e.sink.Send(rec)
+		if err == nil {
+			sentRecords.WithLabelValues(name).Inc()
+			continue
+		}
+		var eerr *encodeError
+		if errors.As(err, &eerr) {
+			reject(cfg, l.Name, remote, reasonMarshal, []byte(fmt.Sprint(rec)), eerr.err)
+			continue
+		}
+		sinkErrors.WithLabelValues(name).Inc()
+		failed++
+	}
+	return failed
+}
+
+// closeSinks closes all running sinks and returns their close errors
+func closeSinks() error {
+	sinksUpdate.Lock()
+	defer sinksUpdate.Unlock()
+	sinksMutex.Lock()
+	running := sinks
+	sinks = make(map[string]*sinkEntry)
+	sinksMutex.Unlock()
+	var errs []error
+	for name, e := range running {
+		if err := e.sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeSink closes given sink logging failure
+func closeSink(name string, sink Sink) {
+	if err := sink.Close(); err != nil {
+		slog.Warn("unable to close sink", "sink", name, "error", err)
+	}
+}
+
+// clientTLSConfig returns TLS configuration of sink connections
+func clientTLSConfig(cfg *config.ClientTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		data, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// sinkHTTPClient returns HTTP client of sinks with given TLS parameters
+func sinkHTTPClient(tlsCfg *config.ClientTLS, timeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsCfg != nil {
+		tlsConfig, err := clientTLSConfig(tlsCfg)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// fieldToken replaces characters which separate or match tokens of NATS
+// subjects and AMQP addresses in record field values
+var fieldToken = strings.NewReplacer(".", "_", "/", "_", "*", "_", ">", "_", "#", "_", " ", "_", "\t", "_").Replace
+
+// expandFields replaces record fields in braces of given template, e.g.
+// cmssw.udp.{site_name}, with their escaped values, fields which are not
+// set are replaced with unknown
+func expandFields(t string, rec Record, escape func(string) string) string {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(t, '{')
+		end := strings.IndexByte(t, '}')
+		if start < 0 || end < start {
+			sb.WriteString(t)
+			return sb.String()
+		}
+		sb.WriteString(t[:start])
+		val := "unknown"
+		if v, ok := rec[t[start+1:end]]; ok && v != nil && fmt.Sprint(v) != "" {
+			val = escape(fmt.Sprint(v))
+		}
+		sb.WriteString(val)
+		t = t[end+1:]
+	}
+}
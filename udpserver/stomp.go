@@ -0,0 +1,104 @@
+package udpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+	"github.com/go-stomp/stomp"
+)
+
+// StompConnection returns Stomp connection
+func StompConnection(cfg config.StompSink) (*stomp.Conn, error) {
+	if cfg.URI == "" {
+		err := errors.New("Unable to connect to Stomp, not URI")
+		return nil, err
+	}
+	if cfg.Login == "" {
+		err := errors.New("Unable to connect to Stomp, not login")
+		return nil, err
+	}
+	if cfg.Password == "" {
+		err := errors.New("Unable to connect to Stomp, not password")
+		return nil, err
+	}
+	conn, err := stomp.Dial("tcp",
+		cfg.URI,
+		stomp.ConnOpt.Login(cfg.Login, cfg.Password),
+		stomp.ConnOpt.HeartBeat(time.Duration(cfg.SendTimeout)*time.Second, time.Duration(cfg.RecvTimeout)*time.Second),
+		stomp.ConnOpt.HeartBeatGracePeriodMultiplier(cfg.HeartBeatGracePeriod),
+	)
+	if err != nil {
+		slog.Error("unable to connect to StompAMQ server", "uri", cfg.URI, "error", err)
+	} else {
+		// never print connection details as they may contain credentials
+		slog.Debug("connected to StompAMQ server", "uri", cfg.URI, "login", cfg.Login)
+	}
+	return conn, err
+}
+
+// stompSink sends records to StompAMQ endpoint
+type stompSink struct {
+	name    string
+	cfg     config.StompSink
+	encoder Encoder
+	mu      sync.Mutex
+	conn    *stomp.Conn
+}
+
+// newStompSink returns StompAMQ sink connected to its server
+func newStompSink(cfg config.Sink, encoder Encoder) *stompSink {
+	s := &stompSink{name: cfg.Name, cfg: cfg.Stomp, encoder: encoder}
+	s.conn, _ = StompConnection(s.cfg)
+	return s
+}
+
+// Send implements Sink interface
+func (s *stompSink) Send(rec Record) error {
+	data, err := s.encoder.Encode(rec)
+	if err != nil {
+		return &encodeError{err}
+	}
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		slog.Debug("sent to AMQ", "sink", s.name, "endpoint", s.cfg.Endpoint, "bytes", len(data), "data", strings.TrimSpace(string(data)))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = errors.New("no Stomp connection")
+	for i := 0; i < s.cfg.Iterations; i++ {
+		err = errors.New("no Stomp connection")
+		if s.conn != nil {
+			err = s.conn.Send(s.cfg.Endpoint, s.cfg.ContentType, data)
+		}
+		if err == nil {
+			slog.Debug("send data to StompAMQ", "sink", s.name, "endpoint", s.cfg.Endpoint, "bytes", len(data))
+			return nil
+		}
+		if i == s.cfg.Iterations-1 {
+			slog.Error("unable to send data", "sink", s.name, "endpoint", s.cfg.Endpoint, "bytes", len(data), "data", string(data), "iteration", i, "error", err)
+		} else {
+			slog.Warn("unable to send data", "sink", s.name, "endpoint", s.cfg.Endpoint, "bytes", len(data), "iteration", i, "error", err)
+		}
+		if s.conn != nil {
+			s.conn.Disconnect()
+		}
+		s.conn, _ = StompConnection(s.cfg)
+	}
+	return err
+}
+
+// Close implements Sink interface
+func (s *stompSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Disconnect()
+	s.conn = nil
+	return err
+}
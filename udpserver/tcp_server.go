@@ -0,0 +1,159 @@
+package udpserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// listenTCP binds TCP or HTTP listener to given address, the connections
+// are wrapped into TLS if listener has TLS configuration
+func listenTCP(l config.Listener, addr string) net.Listener {
+	ln, err := net.Listen(l.Network(), addr)
+	if err == nil && l.TLS != nil {
+		var tlsConfig *tls.Config
+		tlsConfig, err = serverTLSConfig(l.TLS)
+		if err == nil {
+			ln = tls.NewListener(ln, tlsConfig)
+		}
+	}
+	if err != nil {
+		slog.Error("unable to start server", "listener", l.Name, "protocol", l.Protocol, "family", l.Family, "address", addr, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("server started", "listener", l.Name, "protocol", l.Protocol, "family", l.Family, "address", ln.Addr().String(), "tls", l.TLS != nil)
+	return ln
+}
+
+// serverTLSConfig returns TLS configuration of TCP listener
+func serverTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// tcpServer accepts connections of given listener up to the connection limit
+func tcpServer(ln net.Listener, l config.Listener) {
+	defer ln.Close()
+	var open atomic.Int64
+	p := newPipeline(l)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			slog.Error("unable to accept TCP connection", "listener", l.Name, "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		cfg := store.Get()
+		lcfg := p.listener(cfg)
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			if !permitted(cfg, l.Name, canonicalAddr(addr.AddrPort()), addr.AddrPort().Addr(), nil) {
+				conn.Close()
+				continue
+			}
+		}
+		if lcfg.MaxConnections > 0 && open.Load() >= int64(lcfg.MaxConnections) {
+			tcpRefusedConnections.WithLabelValues(l.Name).Inc()
+			slog.Warn("too many TCP connections", "listener", l.Name, "remote", conn.RemoteAddr().String(), "limit", lcfg.MaxConnections)
+			conn.Close()
+			continue
+		}
+		open.Add(1)
+		tcpAcceptedConnections.WithLabelValues(l.Name).Inc()
+		tcpConnections.WithLabelValues(l.Name).Inc()
+		go func() {
+			defer open.Add(-1)
+			defer tcpConnections.WithLabelValues(l.Name).Dec()
+			handleConnection(conn, newPipeline(*lcfg))
+		}()
+	}
+}
+
+// handleConnection reads newline-delimited records from TCP connection
+// and passes them to the pipeline until connection is closed or idle
+func handleConnection(conn net.Conn, p *pipeline) {
+	defer conn.Close()
+	start := time.Now()
+	remote := conn.RemoteAddr().String()
+	var source netip.AddrPort
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		source = addr.AddrPort()
+		remote = canonicalAddr(source)
+	}
+	l := p.listener(store.Get())
+	slog.Debug("TCP connection opened", "listener", l.Name, "remote", remote)
+
+	// lines longer than the maximum UDP buffer size are rejected
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, l.BufSize), 1024*l.BufSize)
+	var lines, size int
+	for {
+		// pick up configuration which may be reloaded at any time
+		cfg := store.Get()
+		l = p.listener(cfg)
+		if l.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(l.IdleTimeout) * time.Second))
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Bytes()
+		size += len(line) + 1
+		if len(line) == 0 {
+			continue
+		}
+		lines++
+		if string(line) == "ping" {
+			pong(cfg, l.Name, remote)
+			continue
+		}
+		if source.IsValid() && rateLimited(l.Name, source.Addr()) {
+			continue
+		}
+		p.handle(cfg, packet{remote: remote, source: source, received: time.Now(), data: line})
+	}
+
+	err := scanner.Err()
+	var nerr net.Error
+	switch {
+	case errors.Is(err, bufio.ErrTooLong):
+		reject(store.Get(), l.Name, remote, reasonTruncated, scanner.Bytes(), fmt.Errorf("line exceeds %d bytes", 1024*l.BufSize))
+	case errors.As(err, &nerr) && nerr.Timeout():
+		slog.Debug("TCP connection is idle", "listener", l.Name, "remote", remote, "idleTimeout", l.IdleTimeout)
+	case err != nil:
+		slog.Debug("TCP connection failed", "listener", l.Name, "remote", remote, "error", err)
+	}
+	duration := time.Since(start)
+	tcpConnectionLines.WithLabelValues(l.Name).Observe(float64(lines))
+	tcpConnectionBytes.WithLabelValues(l.Name).Observe(float64(size))
+	tcpConnectionDuration.WithLabelValues(l.Name).Observe(duration.Seconds())
+	slog.Debug("TCP connection closed", "listener", l.Name, "remote", remote, "lines", lines, "bytes", size, "duration", duration)
+}
@@ -0,0 +1,169 @@
+package udpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/dmwm/udp-collector/config"
+)
+
+// webhookItem is a record waiting to be sent with its encoded form
+type webhookItem struct {
+	rec  Record
+	data json.RawMessage
+}
+
+// webhookEnvelope is a body of webhook request with envelope body
+type webhookEnvelope struct {
+	Sink      string            `json:"sink"`
+	Host      string            `json:"host"`
+	Timestamp int64             `json:"timestamp"`
+	Count     int               `json:"count"`
+	Records   []json.RawMessage `json:"records"`
+}
+
+// webhookData is a data of webhook body template
+type webhookData struct {
+	Sink    string
+	Host    string
+	Time    time.Time
+	Records []Record
+}
+
+// webhookSink sends records to HTTP endpoint, records are batched and
+// delivered asynchronously
+type webhookSink struct {
+	name     string
+	cfg      config.WebhookSink
+	encoder  Encoder
+	template *template.Template
+	client   *http.Client
+	batcher  *batcher[webhookItem]
+}
+
+// newWebhookSink returns sink sending records to configured URL
+func newWebhookSink(cfg config.Sink, encoder Encoder) (*webhookSink, error) {
+	w := cfg.Webhook
+	client, err := sinkHTTPClient(w.TLS, time.Duration(w.Timeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	s := &webhookSink{name: cfg.Name, cfg: w, encoder: encoder, client: client}
+	if w.Body == "template" {
+		if s.template, err = w.ParseTemplate(cfg.Name); err != nil {
+			return nil, err
+		}
+	}
+	s.batcher = newBatcher(cfg.Name, w.BatchSize, time.Duration(w.FlushInterval)*time.Millisecond, s.flush)
+	return s, nil
+}
+
+// Send implements Sink interface
+func (s *webhookSink) Send(rec Record) error {
+	data, err := s.encoder.Encode(rec)
+	if err != nil {
+		return &encodeError{err}
+	}
+	s.batcher.add(webhookItem{rec: rec, data: data})
+	return nil
+}
+
+// Close implements Sink interface, it flushes pending batches
+func (s *webhookSink) Close() error {
+	s.batcher.close()
+	return nil
+}
+
+// body returns request body of given batch
+func (s *webhookSink) body(batch []webhookItem) ([]byte, error) {
+	switch s.cfg.Body {
+	case "envelope":
+		env := webhookEnvelope{Sink: s.name, Host: hostname, Timestamp: time.Now().UnixMilli(), Count: len(batch)}
+		for _, item := range batch {
+			env.Records = append(env.Records, item.data)
+		}
+		return json.Marshal(env)
+	case "template":
+		data := webhookData{Sink: s.name, Host: hostname, Time: time.Now()}
+		for _, item := range batch {
+			data.Records = append(data.Records, item.rec)
+		}
+		var buf bytes.Buffer
+		err := s.template.Execute(&buf, data)
+		return buf.Bytes(), err
+	}
+	// raw records, a single one or array of them if sink batches records
+	if s.cfg.BatchSize == 1 {
+		return batch[0].data, nil
+	}
+	records := make([]json.RawMessage, 0, len(batch))
+	for _, item := range batch {
+		records = append(records, item.data)
+	}
+	return json.Marshal(records)
+}
+
+// flush sends batch to the endpoint retrying failed requests
+func (s *webhookSink) flush(batch []webhookItem) {
+	body, err := s.body(batch)
+	if err != nil {
+		deliveryErrors.WithLabelValues(s.name).Add(float64(len(batch)))
+		slog.Error("unable to create request body", "sink", s.name, "records", len(batch), "error", err)
+		return
+	}
+	backoff := time.Duration(s.cfg.RetryBackoff) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == s.cfg.Retries {
+			deliveryErrors.WithLabelValues(s.name).Add(float64(len(batch)))
+			slog.Error("unable to send records", "sink", s.name, "url", s.cfg.URL, "records", len(batch), "attempts", attempt+1, "error", err)
+			return
+		}
+		slog.Warn("retrying request", "sink", s.name, "url", s.cfg.URL, "records", len(batch), "attempt", attempt+1, "delay", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends request with given body and tells if failed request may be retried
+func (s *webhookSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(s.cfg.Method, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", s.encoder.ContentType())
+	for key, val := range s.cfg.Headers {
+		req.Header.Set(key, val)
+	}
+	for key, val := range s.cfg.SecretHeaders {
+		req.Header.Set(key, val)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if s.success(resp.StatusCode) {
+		return false, nil
+	}
+	return retryableStatus(resp.StatusCode), fmt.Errorf("request failed with status %d: %s", resp.StatusCode, data)
+}
+
+// success checks whether response code means the records were delivered
+func (s *webhookSink) success(code int) bool {
+	if len(s.cfg.SuccessCodes) == 0 {
+		return code >= 200 && code < 300
+	}
+	return slices.Contains(s.cfg.SuccessCodes, code)
+}
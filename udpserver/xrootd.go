@@ -0,0 +1,414 @@
+package udpserver
+
+// xrootd - decoder of XRootD binary monitoring packets (XrdXrootdMon f-stream,
+//          g-stream and dictionary maps) into records with the same field
+//          names as CMSSW file access reports
+//
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// XRootD monitoring packet codes
+const (
+	xrdServerInfo = '=' // server identification
+	xrdDictID     = 'd' // dictionary id to user and path mapping
+	xrdFStream    = 'f' // file stream
+	xrdGStream    = 'g' // generic stream
+	xrdAppInfo    = 'i' // user application info
+	xrdUserLogin  = 'u' // user login mapping
+)
+
+// XRootD f-stream record types
+const (
+	xrdIsClose = 0
+	xrdIsOpen  = 1
+	xrdIsTime  = 2
+	xrdIsXfr   = 3
+	xrdIsDisc  = 4
+)
+
+// XRootD f-stream record flags
+const (
+	xrdForced = 0x01 // file was forcibly closed
+	xrdHasLFN = 0x02 // open record contains user id and file name
+	xrdHasOPS = 0x04 // close record contains operation statistics
+	xrdHasSSQ = 0x08 // close record contains sums of squares
+)
+
+// sizes of XRootD monitoring structures
+const (
+	xrdHeaderSize  = 8  // XrdXrootdMonHeader
+	xrdFileHdrSize = 8  // XrdXrootdMonFileHdr
+	xrdTODSize     = 24 // XrdXrootdMonFileTOD
+	xrdXfrSize     = 24 // XrdXrootdMonStatXFR
+	xrdOpsSize     = 48 // XrdXrootdMonStatOPS
+	xrdSSQSize     = 32 // XrdXrootdMonStatSSQ
+	xrdGSSize      = 16 // XrdXrootdMonGS without header
+)
+
+// maximum number of dictionary entries kept per server
+const xrdMaxEntries = 100000
+
+// servers which did not send packets within this period are forgotten
+const xrdServerTTL = 24 * time.Hour
+
+// errXrdTruncated is returned for truncated XRootD packets
+var errXrdTruncated = errors.New("truncated XRootD monitoring packet")
+
+// xrdUser describes XRootD user session
+type xrdUser struct {
+	id      string // user id in prot/user.pid:sid@host form
+	host    string // client host name
+	dn      string // user distinguished name
+	appInfo string // application info
+}
+
+// xrdFile describes open file
+type xrdFile struct {
+	lfn    string // logical file name
+	size   int64  // file size at open
+	userID uint32 // dictionary id of the user
+	start  int32  // open time
+}
+
+// xrdServer keeps dictionary state of single XRootD server
+type xrdServer struct {
+	stod     int32              // server start time
+	host     string             // server host name
+	site     string             // server site name
+	users    map[uint32]xrdUser // user sessions by dictionary id
+	paths    map[uint32]string  // file paths by dictionary id
+	files    map[uint32]xrdFile // open files by file id
+	lastSeen time.Time          // time of last packet
+}
+
+// newXrdServer returns new server state
+func newXrdServer(stod int32) *xrdServer {
+	return &xrdServer{
+		stod:  stod,
+		users: make(map[uint32]xrdUser),
+		paths: make(map[uint32]string),
+		files: make(map[uint32]xrdFile),
+	}
+}
+
+// XrootdDecoder decodes XRootD monitoring packets keeping dictionary
+// state per server to resolve file and user ids
+type XrootdDecoder struct {
+	mu      sync.Mutex
+	servers map[string]*xrdServer
+	pruned  time.Time
+}
+
+// NewXrootdDecoder returns new XrootdDecoder
+func NewXrootdDecoder() *XrootdDecoder {
+	return &XrootdDecoder{servers: make(map[string]*xrdServer)}
+}
+
+// Decode implements Decoder interface
+func (d *XrootdDecoder) Decode(source string, data []byte) ([]Record, []*DecodeError) {
+	if len(data) < xrdHeaderSize {
+		return nil, []*DecodeError{{Reason: reasonTruncated, Data: data, Err: errXrdTruncated}}
+	}
+	code := data[0]
+	plen := int(binary.BigEndian.Uint16(data[2:4]))
+	stod := int32(binary.BigEndian.Uint32(data[4:8]))
+	if plen < xrdHeaderSize || plen > len(data) {
+		return nil, []*DecodeError{{Reason: reasonTruncated, Data: data, Err: errXrdTruncated}}
+	}
+	data = data[:plen]
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	srv := d.server(source, stod)
+	var records []Record
+	var err error
+	switch code {
+	case xrdServerInfo, xrdDictID, xrdAppInfo, xrdUserLogin:
+		err = srv.addMapping(code, data[xrdHeaderSize:])
+	case xrdFStream:
+		records, err = srv.fileStream(source, data[xrdHeaderSize:])
+	case xrdGStream:
+		records, err = srv.genericStream(source, data[xrdHeaderSize:])
+	}
+	if err != nil {
+		reason := reasonMalformed
+		if errors.Is(err, errXrdTruncated) {
+			reason = reasonTruncated
+		}
+		return records, []*DecodeError{{Reason: reason, Data: data, Err: err}}
+	}
+	return records, nil
+}
+
+// server returns state of the server identified by source address and its
+// start time, the state is reset when server restarts
+func (d *XrootdDecoder) server(source string, stod int32) *xrdServer {
+	now := time.Now()
+	if now.Sub(d.pruned) > time.Hour {
+		for key, srv := range d.servers {
+			if now.Sub(srv.lastSeen) > xrdServerTTL {
+				delete(d.servers, key)
+			}
+		}
+		d.pruned = now
+	}
+	srv, ok := d.servers[source]
+	if !ok || srv.stod != stod {
+		srv = newXrdServer(stod)
+		d.servers[source] = srv
+	}
+	srv.lastSeen = now
+	return srv
+}
+
+// addMapping adds dictionary mapping of XrdXrootdMonMap packet
+func (s *xrdServer) addMapping(code byte, data []byte) error {
+	if len(data) < 4 {
+		return errXrdTruncated
+	}
+	dictID := binary.BigEndian.Uint32(data[:4])
+	info := string(bytes.TrimRight(data[4:], "\x00"))
+	userID, rest, _ := strings.Cut(info, "\n")
+	switch code {
+	case xrdServerInfo:
+		s.host = xrdHost(userID)
+		if params, err := url.ParseQuery(strings.TrimPrefix(rest, "&")); err == nil {
+			s.site = params.Get("site")
+		}
+	case xrdDictID:
+		if len(s.paths) >= xrdMaxEntries {
+			s.paths = make(map[uint32]string)
+		}
+		s.paths[dictID] = rest
+	case xrdUserLogin:
+		if len(s.users) >= xrdMaxEntries {
+			s.users = make(map[uint32]xrdUser)
+		}
+		user := xrdUser{id: userID, host: xrdHost(userID)}
+		if params, err := url.ParseQuery(strings.TrimPrefix(rest, "&")); err == nil {
+			if h := params.Get("h"); h != "" {
+				user.host = h
+			}
+			user.dn = params.Get("m")
+		}
+		s.users[dictID] = user
+	case xrdAppInfo:
+		for id, user := range s.users {
+			if user.id == userID {
+				user.appInfo = rest
+				s.users[id] = user
+			}
+		}
+	}
+	return nil
+}
+
+// fileStream decodes f-stream packet and returns records of closed files
+func (s *xrdServer) fileStream(source string, data []byte) ([]Record, error) {
+	if len(data) < xrdTODSize {
+		return nil, errXrdTruncated
+	}
+	tBeg := int32(binary.BigEndian.Uint32(data[8:12]))
+	tEnd := int32(binary.BigEndian.Uint32(data[12:16]))
+	data = data[xrdTODSize:]
+	var records []Record
+	for len(data) >= xrdFileHdrSize {
+		recType := data[0]
+		recFlag := data[1]
+		recSize := int(binary.BigEndian.Uint16(data[2:4]))
+		id := binary.BigEndian.Uint32(data[4:8])
+		if recSize < xrdFileHdrSize || recSize > len(data) {
+			return records, errXrdTruncated
+		}
+		body := data[xrdFileHdrSize:recSize]
+		data = data[recSize:]
+		switch recType {
+		case xrdIsOpen:
+			if len(body) < 8 {
+				return records, errXrdTruncated
+			}
+			file := xrdFile{size: int64(binary.BigEndian.Uint64(body[:8])), start: tBeg}
+			if recFlag&xrdHasLFN != 0 && len(body) >= 12 {
+				file.userID = binary.BigEndian.Uint32(body[8:12])
+				file.lfn = string(bytes.TrimRight(body[12:], "\x00"))
+			} else {
+				file.lfn = s.paths[id]
+			}
+			if len(s.files) >= xrdMaxEntries {
+				s.files = make(map[uint32]xrdFile)
+			}
+			s.files[id] = file
+		case xrdIsClose:
+			rec, err := s.closeRecord(source, id, recFlag, body, tEnd)
+			if err != nil {
+				return records, err
+			}
+			records = append(records, rec)
+		case xrdIsDisc:
+			delete(s.users, id)
+		case xrdIsTime:
+			if len(body) >= 8 {
+				tBeg = int32(binary.BigEndian.Uint32(body[:4]))
+				tEnd = int32(binary.BigEndian.Uint32(body[4:8]))
+			}
+		}
+	}
+	return records, nil
+}
+
+// serverHost returns host name reported by the server or host of its
+// source address, IPv6 addresses are returned without brackets
+func (s *xrdServer) serverHost(source string) string {
+	if s.host != "" {
+		return s.host
+	}
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		return source
+	}
+	return host
+}
+
+// closeRecord creates record of closed file in CMSSW layout
+func (s *xrdServer) closeRecord(source string, fileID uint32, flag byte, body []byte, tEnd int32) (Record, error) {
+	if len(body) < xrdXfrSize {
+		return nil, errXrdTruncated
+	}
+	readBytes := int64(binary.BigEndian.Uint64(body[0:8]))
+	readvBytes := int64(binary.BigEndian.Uint64(body[8:16]))
+	writeBytes := int64(binary.BigEndian.Uint64(body[16:24]))
+	body = body[xrdXfrSize:]
+
+	file, ok := s.files[fileID]
+	if !ok {
+		file = xrdFile{lfn: s.paths[fileID], start: tEnd}
+	}
+	delete(s.files, fileID)
+	user := s.users[file.userID]
+	serverHost := s.serverHost(source)
+	clientHost, clientDomain, _ := strings.Cut(user.host, ".")
+	serverName, serverDomain := serverHost, ""
+	if _, err := netip.ParseAddr(serverHost); err != nil {
+		serverName, serverDomain, _ = strings.Cut(serverHost, ".")
+	}
+
+	rec := Record{
+		"file_lfn":            file.lfn,
+		"file_size":           file.size,
+		"read_bytes":          readBytes + readvBytes,
+		"read_bytes_at_close": readBytes + readvBytes,
+		"read_single_bytes":   readBytes,
+		"read_vector_bytes":   readvBytes,
+		"write_bytes":         writeBytes,
+		"client_host":         clientHost,
+		"client_domain":       clientDomain,
+		"server_host":         serverName,
+		"server_domain":       serverDomain,
+		"site_name":           s.site,
+		"user_dn":             user.dn,
+		"app_info":            user.appInfo,
+		"start_time":          file.start,
+		"end_time":            tEnd,
+		"forced_close":        flag&xrdForced != 0,
+		"unique_id":           fmt.Sprintf("xrootd-%s-%d-%d", serverHost, s.stod, fileID),
+		"fallback":            false,
+	}
+	if flag&xrdHasOPS != 0 {
+		if len(body) < xrdOpsSize {
+			return nil, errXrdTruncated
+		}
+		readOps := int32(binary.BigEndian.Uint32(body[0:4]))
+		readvOps := int32(binary.BigEndian.Uint32(body[4:8]))
+		rsegs := int64(binary.BigEndian.Uint64(body[16:24]))
+		rec["read_single_operations"] = readOps
+		rec["read_vector_operations"] = readvOps
+		rec["read_vector_count_sum"] = rsegs
+		rec["read_single_average"] = average(float64(readBytes), readOps)
+		rec["read_vector_average"] = average(float64(readvBytes), readvOps)
+		rec["read_vector_ndocs_average"] = average(float64(rsegs), readvOps)
+		body = body[xrdOpsSize:]
+		if flag&xrdHasSSQ != 0 {
+			if len(body) < xrdSSQSize {
+				return nil, errXrdTruncated
+			}
+			ssqRead := math.Float64frombits(binary.BigEndian.Uint64(body[0:8]))
+			ssqReadv := math.Float64frombits(binary.BigEndian.Uint64(body[8:16]))
+			ssqRsegs := math.Float64frombits(binary.BigEndian.Uint64(body[16:24]))
+			rec["read_single_sigma"] = sigma(ssqRead, float64(readBytes), readOps)
+			rec["read_vector_sigma"] = sigma(ssqReadv, float64(readvBytes), readvOps)
+			rec["read_vector_ndocs_sigma"] = sigma(ssqRsegs, float64(rsegs), readvOps)
+		}
+	}
+	return rec, nil
+}
+
+// genericStream decodes g-stream packet whose payload consists of newline
+// delimited JSON records
+func (s *xrdServer) genericStream(source string, data []byte) ([]Record, error) {
+	if len(data) < xrdGSSize {
+		return nil, errXrdTruncated
+	}
+	provider := string(rune(data[8])) // provider code is the top byte of server id
+	serverHost := s.serverHost(source)
+	var records []Record
+	for _, line := range bytes.Split(data[xrdGSSize:], []byte("\n")) {
+		line = bytes.Trim(line, "\x00 \r\t")
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return records, err
+		}
+		if rec == nil {
+			return records, errNotObject
+		}
+		rec["server_host"] = serverHost
+		rec["site_name"] = s.site
+		rec["gstream_provider"] = provider
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// xrdHost returns host name of XRootD user id in prot/user.pid:sid@host form
+func xrdHost(userID string) string {
+	if i := strings.LastIndex(userID, "@"); i >= 0 {
+		return userID[i+1:]
+	}
+	return userID
+}
+
+// average returns average value of given number of operations
+func average(total float64, n int32) float64 {
+	if n <= 0 {
+		return 0
+	}
+	return total / float64(n)
+}
+
+// sigma returns standard deviation from sum of squares, total and number of operations
+func sigma(ssq, total float64, n int32) float64 {
+	if n <= 0 {
+		return 0
+	}
+	avg := total / float64(n)
+	v := ssq/float64(n) - avg*avg
+	if v <= 0 {
+		return 0
+	}
+	return math.Sqrt(v)
+}
@@ -0,0 +1,96 @@
+package udpserver
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// gstreamPacket returns XRootD g-stream packet with given payload
+func gstreamPacket(payload string) []byte {
+	data := make([]byte, xrdHeaderSize+xrdGSSize, xrdHeaderSize+xrdGSSize+len(payload))
+	data[0] = xrdGStream
+	binary.BigEndian.PutUint32(data[4:8], 1700000000)
+	data[xrdHeaderSize+8] = 'C' // provider code
+	data = append(data, payload...)
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	return data
+}
+
+// fstreamClosePacket returns XRootD f-stream packet with close record of
+// file never opened
+func fstreamClosePacket() []byte {
+	data := make([]byte, xrdHeaderSize+xrdTODSize+xrdFileHdrSize+xrdXfrSize)
+	data[0] = xrdFStream
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	binary.BigEndian.PutUint32(data[4:8], 1700000000)
+	rec := data[xrdHeaderSize+xrdTODSize:]
+	rec[0] = xrdIsClose
+	binary.BigEndian.PutUint16(rec[2:4], xrdFileHdrSize+xrdXfrSize)
+	binary.BigEndian.PutUint32(rec[4:8], 7)
+	return data
+}
+
+// TestXrootdServerHost checks server host taken from IPv4 and IPv6
+// source addresses
+func TestXrootdServerHost(t *testing.T) {
+	tests := []struct {
+		source string
+		host   string
+	}{
+		{"192.0.2.1:1094", "192.0.2.1"},
+		{"[2001:db8::1]:1094", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			d := NewXrootdDecoder()
+			records, errs := d.Decode(tt.source, gstreamPacket("{\"event\": \"open\"}\n"))
+			if len(records) != 1 || len(errs) > 0 {
+				t.Fatalf("got records %v and errors %v", records, errs)
+			}
+			if got := records[0]["server_host"]; got != tt.host {
+				t.Errorf("g-stream server_host %v, want %s", got, tt.host)
+			}
+			records, errs = d.Decode(tt.source, fstreamClosePacket())
+			if len(records) != 1 || len(errs) > 0 {
+				t.Fatalf("got records %v and errors %v", records, errs)
+			}
+			if got := records[0]["server_host"]; got != tt.host || records[0]["server_domain"] != "" {
+				t.Errorf("f-stream server_host %v and server_domain %v, want %s", got, records[0]["server_domain"], tt.host)
+			}
+		})
+	}
+}
+
+// TestXrootdGenericStream checks decoding of g-stream JSON records
+func TestXrootdGenericStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		records int
+		reason  string
+	}{
+		{"records", "{\"event\": \"open\"}\n{\"event\": \"close\"}\n", 2, ""},
+		{"null", "null\n", 0, reasonMalformed},
+		{"null after record", "{\"event\": \"open\"}\nnull\n", 1, reasonMalformed},
+		{"not object", "[1, 2]\n", 0, reasonMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, errs := NewXrootdDecoder().Decode("192.0.2.1:1094", gstreamPacket(tt.payload))
+			if len(records) != tt.records {
+				t.Errorf("got %d records, want %d", len(records), tt.records)
+			}
+			for _, rec := range records {
+				if rec["server_host"] != "192.0.2.1" || rec["gstream_provider"] != "C" {
+					t.Errorf("unexpected record %v", rec)
+				}
+			}
+			if tt.reason == "" && len(errs) > 0 {
+				t.Fatalf("unexpected errors %v", errs)
+			}
+			if tt.reason != "" && (len(errs) != 1 || errs[0].Reason != tt.reason) {
+				t.Fatalf("got errors %v, want one %s error", errs, tt.reason)
+			}
+		})
+	}
+}
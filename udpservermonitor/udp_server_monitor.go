@@ -1,15 +1,21 @@
 package udpservermonitor
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/dmwm/udp-collector/config"
+	"github.com/dmwm/udp-collector/udpserver"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/procfs"
@@ -23,9 +29,13 @@ import (
 )
 
 // global variables
-var monitorInterval time.Duration
 var lastUpdate time.Time
-var verbose bool
+var store *config.Store
+
+// monitorInterval returns monitor interval of current configuration
+func monitorInterval() time.Duration {
+	return time.Duration(store.Get().MonitorInterval) * time.Second
+}
 
 type Exporter struct {
 	memoryPercent    prometheus.Gauge
@@ -165,30 +175,30 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		e.memoryPercent.Set(memInfo.UsedPercent)
 		e.memoryTotal.Set(float64(memInfo.Total))
 		e.memoryFree.Set(float64(memInfo.Free))
-	} else if verbose {
-		log.Printf("Failed to collect memory metrics: %v", err)
+	} else {
+		slog.Debug("failed to collect memory metrics", "error", err)
 	}
 
 	if swapInfo, err := mem.SwapMemory(); err == nil {
 		e.swapPercent.Set(swapInfo.UsedPercent)
 		e.swapTotal.Set(float64(swapInfo.Total))
 		e.swapFree.Set(float64(swapInfo.Free))
-	} else if verbose {
-		log.Printf("Failed to collect swap metrics: %v", err)
+	} else {
+		slog.Debug("failed to collect swap metrics", "error", err)
 	}
 
 	if cpuPercent, err := cpu.Percent(time.Millisecond, false); err == nil && len(cpuPercent) > 0 {
 		e.cpuPercent.Set(cpuPercent[0])
-	} else if verbose {
-		log.Printf("Failed to collect CPU percent: %v", err)
+	} else {
+		slog.Debug("failed to collect CPU percent", "error", err)
 	}
 	
 	if loadAvg, err := load.Avg(); err == nil {
 		e.load1.Set(loadAvg.Load1)
 		e.load5.Set(loadAvg.Load5)
 		e.load15.Set(loadAvg.Load15)
-	} else if verbose {
-		log.Printf("Failed to collect load metrics: %v", err)
+	} else {
+		slog.Debug("failed to collect load metrics", "error", err)
 	}
 
 	if proc, err := procfs.NewProc(os.Getpid()); err == nil {
@@ -204,8 +214,8 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 			e.maxFDs.Set(float64(limits.OpenFiles))
 			e.maxVSize.Set(float64(limits.AddressSpace))
 		}
-	} else if verbose {
-		log.Printf("Failed to collect process metrics: %v", err)
+	} else {
+		slog.Debug("failed to collect process metrics", "error", err)
 	}
 
 	if proc, err := process.NewProcess(int32(os.Getpid())); err == nil {
@@ -221,8 +231,8 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		if openFiles, err := proc.OpenFiles(); err == nil {
 			e.openFiles.Set(float64(len(openFiles)))
 		}
-	} else if verbose {
-		log.Printf("Failed to collect process metrics: %v", err)
+	} else {
+		slog.Debug("failed to collect process metrics", "error", err)
 	}
 
 	// Send collected metrics to Prometheus
@@ -248,19 +258,61 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
     e.openFiles.Collect(ch)
 }
 
-func udpPing(hostPort string) {
-	// Connect to udp server
-	conn, err := net.Dial("udp", hostPort)
-	if err != nil {
-		if verbose {
-			log.Printf("Unable to contact UDP server at %s: %v", hostPort, err)
+// ping sends ping message to given listener, TCP listeners receive it
+// as a separate line and HTTP listeners as request to /ping path
+func ping(l config.Listener) {
+	hostPort := l.LocalAddress()
+	// the server is on local host, so skip verification of its name
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if l.Protocol == "http" {
+		rurl := "http://" + hostPort + "/ping"
+		if l.TLS != nil {
+			rurl = "https://" + hostPort + "/ping"
+		}
+		client := &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}
+		resp, err := client.Get(rurl)
+		if err != nil {
+			slog.Debug("unable to contact server", "listener", l.Name, "address", hostPort, "error", err)
+			return
 		}
+		resp.Body.Close()
+		return
+	}
+
+	// Connect to udp or tcp server
+	var conn net.Conn
+	var err error
+	if l.TLS != nil {
+		conn, err = tls.Dial("tcp", hostPort, tlsConfig)
+	} else {
+		conn, err = net.Dial(l.Protocol, hostPort)
+	}
+	if err != nil {
+		slog.Debug("unable to contact server", "listener", l.Name, "address", hostPort, "error", err)
 		return
 	}
 	defer conn.Close()
 
 	// write ping message
-	conn.Write([]byte("ping"))
+	msg := "ping"
+	if l.Protocol == "tcp" {
+		msg += "\n"
+	}
+	conn.Write([]byte(msg))
+}
+
+// pingListener returns listener which is pinged by the monitor,
+// the first UDP listener is preferred
+func pingListener(cfg *config.Configuration) config.Listener {
+	for _, l := range cfg.Listeners {
+		if l.Protocol == "udp" {
+			return l
+		}
+	}
+	return cfg.Listeners[0]
 }
 
 // requestHandler helper function for our monitoring server
@@ -272,9 +324,7 @@ func requestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 	data, err := io.ReadAll(r.Body)
-	if verbose {
-		log.Println("received", string(data), r.Method, r.RemoteAddr)
-	}
+	slog.Debug("received monitor request", "data", string(data), "method", r.Method, "remote", r.RemoteAddr)
 	if err == nil {
 		if string(data) == "pong" {
 			lastUpdate = time.Now()
@@ -291,7 +341,7 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
     }
 
     // Calculate the threshold time
-    thresholdTime := time.Now().Add(-3 * monitorInterval)
+    thresholdTime := time.Now().Add(-3 * monitorInterval())
 
     if lastUpdate.After(thresholdTime) {
         w.WriteHeader(http.StatusOK)
@@ -302,29 +352,86 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
     }
 }
 
-func StartMonitor(config string) {
-	// parse config file
-	data, e := os.ReadFile(config)
-	if e != nil {
-		log.Fatalf("Unable to read config file: %v\n", config)
-		os.Exit(1)
+// reloadAllowed checks if reload request comes from local host or carries
+// monitor bearer token
+func reloadAllowed(cfg *config.Configuration, r *http.Request) bool {
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil && addr.Addr().Unmap().IsLoopback() {
+		return true
 	}
-	var c map[string]interface{}
-	e = json.Unmarshal(data, &c)
-	if e != nil {
-		log.Fatalf("Unable to unmarshal data: %v\n", data)
+	if cfg.MonitorToken == "" {
+		return false
 	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MonitorToken)) == 1
+}
 
-	// setup variables from config parameters
-	hostPort := fmt.Sprintf(":%d", int64(c["port"].(float64)))
-	monHostPort := fmt.Sprintf(":%d", int64(c["monitorPort"].(float64)))
-	monitorInterval = time.Duration(int64(c["monitorInterval"].(float64))) * time.Second
-	verbose = c["verbose"].(bool)
-	if verbose {
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
-	} else {
-		log.SetFlags(log.LstdFlags)
+// reloadHandler re-reads configuration file and reports applied changes,
+// requests from other hosts require monitor bearer token
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if cfg := store.Get(); !reloadAllowed(cfg, r) {
+		slog.Warn("unauthorized reload request", "remote", r.RemoteAddr)
+		if cfg.MonitorToken == "" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, "reload is allowed from local host only")
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="udp_collector"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "invalid or missing bearer token")
+		return
+	}
+	changes, err := store.Reload()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
 	}
+	if changes == nil {
+		changes = []config.Change{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// rejectedHandler returns last packets rejected by the udp server
+func rejectedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	packets := udpserver.Rejected.List()
+	if packets == nil {
+		packets = []udpserver.RejectedPacket{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(packets)
+}
+
+// offendersHandler returns sources with the most packets over rate limits
+func offendersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	offenders := udpserver.TopOffenders(store.Get().RateLimit.TopOffenders)
+	if offenders == nil {
+		offenders = []udpserver.Offender{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(offenders)
+}
+
+// StartMonitor starts monitoring server with configuration from given store
+func StartMonitor(s *config.Store) {
+	store = s
+	cfg := store.Get()
+
+	// setup variables from config parameters
+	monHostPort := fmt.Sprintf(":%d", cfg.MonitorPort)
 
 	lastUpdate = time.Now()
 
@@ -332,7 +439,7 @@ func StartMonitor(config string) {
 	go func() {
 		for {
 			time.Sleep(5 * time.Second)
-			udpPing(hostPort)
+			ping(pingListener(store.Get()))
 		}
 	}()
 
@@ -342,10 +449,14 @@ func StartMonitor(config string) {
 	// start our monitoring server
 	http.Handle("/metrics", promhttp.Handler())
 	http.HandleFunc("/health", healthCheckHandler)
+	http.HandleFunc("/reload", reloadHandler)
+	http.HandleFunc("/rejected", rejectedHandler)
+	http.HandleFunc("/offenders", offendersHandler)
 	http.HandleFunc("/", requestHandler)
 
-	log.Printf("Starting monitoring server at %s", monHostPort)
+	slog.Info("starting monitoring server", "address", monHostPort)
 	if err := http.ListenAndServe(monHostPort, nil); err != nil {
-		log.Fatalf("Failed to start HTTP server: %v", err)
+		slog.Error("failed to start HTTP server", "address", monHostPort, "error", err)
+		os.Exit(1)
 	}
 }
\ No newline at end of file